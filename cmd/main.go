@@ -3,83 +3,179 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chzyer/readline"
+	"github.com/hneemann/parser2"
 	"github.com/hneemann/parser2/funcGen"
 	"github.com/hneemann/parser2/value"
 )
 
-func toUnderlying(v value.Value) (o any) {
-	switch t := v.(type) {
-	case value.Bool:
-		o, _ = t.ToBool()
-	case value.Int:
-		to, _ := t.ToInt()
-		o = float64(to)
-	case value.Float:
-		o, _ = t.ToFloat()
-	case value.String:
-		o, _ = t.ToString(funcGen.Stack[value.Value]{})
-	case value.Map:
-		m := make(map[string]any, t.Size())
-		t.Iter(func(key string, v value.Value) bool {
-			m[key] = toUnderlying(v)
-			return true
-		})
-		o = m
-	default:
-		panic(fmt.Sprintf("%T conversion to underlying type not supported by jit", t))
+func defaultJitCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
 	}
-	return
+	return filepath.Join(dir, "parser2-jit")
 }
 
-func toValue(v any) value.Value {
-	switch t := v.(type) {
-	case int:
-		return value.Int(t)
-	case float64:
-		return value.Float(t)
-	case bool:
-		return value.Bool(t)
-	case string:
-		return value.String(t)
-	case map[string]any:
-		m := make(value.RealMap, len(t))
-		for k, v := range t {
-			m[k] = toValue(v)
+// writeProfile writes a profile to the file with the given name
+func writeProfile(name string, write func(w io.Writer) error) {
+	if name == "" {
+		return
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		log.Println("could not create profile:", err)
+		return
+	}
+	defer f.Close()
+	if err := write(f); err != nil {
+		log.Println("could not write profile:", err)
+	}
+}
+
+const debugHelp = `debugger commands:
+  s          step into the next line
+  n          step over the next line
+  o          step out of the current function
+  c          continue until a breakpoint is reached
+  b <line>   set a breakpoint
+  d <line>   delete a breakpoint
+  v          show all variables
+  p <name>   print a variable
+  q          abort the evaluation`
+
+// debugUI is the command line interface of the debugger
+type debugUI struct {
+	debugger *funcGen.Debugger[value.Value]
+	rl       *readline.Instance
+	source   []string
+}
+
+func newDebugUI(parser *value.FunctionGenerator) *debugUI {
+	d := &debugUI{}
+	d.debugger = funcGen.NewDebugger[value.Value](d.stopped)
+	parser.SetDebugHook(d.debugger)
+	return d
+}
+
+// start prepares the debugger to debug the given source
+func (d *debugUI) start(rl *readline.Instance, source string) {
+	d.rl = rl
+	d.source = strings.Split(source, "\n")
+	d.debugger.Reset()
+	d.debugger.Break()
+	fmt.Println(debugHelp)
+}
+
+func (d *debugUI) stopped(e *funcGen.DebugEvent[value.Value], depth int) funcGen.DebugCommand {
+	line := e.Node.GetLine()
+	text := ""
+	if int(line) <= len(d.source) {
+		text = strings.TrimSpace(d.source[line-1])
+	}
+	fmt.Printf("[%d] line %d: %s\n", depth, line, text)
+
+	prompt := d.rl.Config.Prompt
+	defer d.rl.SetPrompt(prompt)
+	d.rl.SetPrompt("dbg> ")
+	for {
+		input, err := d.rl.Readline()
+		if err != nil {
+			return funcGen.DebugAbort
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(input), " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case "s":
+			return funcGen.DebugStepInto
+		case "n", "":
+			return funcGen.DebugStepOver
+		case "o":
+			return funcGen.DebugStepOut
+		case "c":
+			return funcGen.DebugContinue
+		case "q":
+			return funcGen.DebugAbort
+		case "b", "d":
+			l, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Println("invalid line:", arg)
+				continue
+			}
+			if cmd == "b" {
+				d.debugger.SetBreakpoint(parser2.Line(l))
+			} else {
+				d.debugger.ClearBreakpoint(parser2.Line(l))
+			}
+			fmt.Println("breakpoints:", d.debugger.Breakpoints())
+		case "v":
+			for _, n := range e.Variables() {
+				v, _ := e.Variable(n)
+				fmt.Printf("%s = %s\n", n, d.toString(v))
+			}
+		case "p":
+			if v, ok := e.Variable(arg); ok {
+				fmt.Printf("%s = %s\n", arg, d.toString(v))
+			} else {
+				fmt.Println("variable not found:", arg)
+			}
+		default:
+			fmt.Println(debugHelp)
 		}
-		return value.NewMap(m)
-	default:
-		panic(fmt.Sprintf("%T conversion to high level type not supported by jit", t))
 	}
 }
 
+// toString converts a value to a string. Lists are not evaluated, because
+// the evaluation could run into a breakpoint.
+func (d *debugUI) toString(v value.Value) string {
+	if _, ok := v.(*value.List); ok {
+		return "<list>"
+	}
+	s, err := v.ToString(funcGen.NewEmptyStack[value.Value]())
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return s
+}
+
 func main() {
 	parser := value.New()
 	parser.GetParser().AllowComments()
 	jitEnabled := flag.Bool("jit", false, "enable/disable the just in time compiler")
 	listOptimizationEnabled := flag.Bool("list-optimization", true, "enable/disable list reuse optimization")
+	jitCache := flag.String("jit-cache", defaultJitCache(), "directory the jit compiled plugins are cached in, empty disables the cache")
+	jitCacheSize := flag.Int64("jit-cache-size", 256<<20, "maximum size of the jit cache in bytes")
+	jitClearCache := flag.Bool("jit-clear-cache", false, "remove all plugins from the jit cache")
+	jitThreshold := flag.Int("jit-threshold", funcGen.DefaultJitThreshold, "number of calls after which a function is compiled")
+	jitVerbose := flag.Bool("jit-verbose", false, "log the events of the jit including the generated source")
+	debugEnabled := flag.Bool("debug", false, "evaluate in the interactive debugger")
+	profile := flag.String("profile", "", "write a pprof profile of the script to the given file")
+	profileFolded := flag.String("profile-folded", "", "write the folded stacks of the script used to create flame graphs to the given file")
+	profileAllocs := flag.Bool("profile-allocs", false, "also profile the allocations, which slows down the evaluation")
 	flag.Parse()
+	parser.SetJitThreshold(*jitThreshold)
 	if *jitEnabled {
 		log.Println("[JIT] enabled, starting up")
-		parser.SetJit()
-		parser.GetJit().UnderlyingToValue = toValue
-		parser.GetJit().ValueToUnderlying = toUnderlying
-		parser.GetJit().TypeToString = func(v value.Value) string {
-			switch v.(type) {
-			case value.String:
-				return "string"
-			case value.Float, value.Int:
-				return "float64"
-			case value.Bool:
-				return "bool"
-			case value.Map:
-				return "map[string]any"
-			default:
-				return "any"
+		parser.EnableJit()
+		jit := parser.GetJit()
+		jit.CacheDir = *jitCache
+		jit.CacheMaxSize = *jitCacheSize
+		jit.OnEvent = func(e funcGen.JitEvent) {
+			if e.Kind != funcGen.JitSource || *jitVerbose {
+				log.Println("[JIT]", e)
+			}
+		}
+		if *jitClearCache {
+			if err := jit.ClearCache(); err != nil {
+				log.Println("[JIT] could not clear the cache", err)
 			}
 		}
 	}
@@ -88,6 +184,17 @@ func main() {
 		parser.SetLetPostOptimizer(nil)
 	}
 
+	var debug *debugUI
+	if *debugEnabled {
+		debug = newDebugUI(parser)
+	}
+
+	var profiler *funcGen.Profiler
+	if *profile != "" || *profileFolded != "" {
+		profiler = funcGen.NewProfiler(*profileAllocs)
+		parser.SetProfiler(profiler)
+	}
+
 	if len(flag.Args()) >= 1 {
 		fileContent, err := os.ReadFile(flag.Arg(0))
 		start := time.Now()
@@ -97,18 +204,33 @@ func main() {
 			return
 		}
 
+		if debug != nil {
+			rl, err := readline.NewEx(&readline.Config{})
+			if err != nil {
+				panic(err)
+			}
+			defer rl.Close()
+			debug.start(rl, string(fileContent))
+		}
+
 		log.Println("Starting eval")
 		result, err := f.Eval()
 		if err != nil {
-			log.Fatalln("Eval error:", err)
+			log.Fatalf("Eval error: %+v\n", err)
 			return
 		}
 		log.Println(result, time.Since(start))
+		if profiler != nil {
+			writeProfile(*profile, profiler.WritePprof)
+			writeProfile(*profileFolded, profiler.WriteFolded)
+		}
 		if *jitEnabled {
 			jit := parser.GetJit()
 			jit.Cancel()
 			<-jit.Ctx.Done()
-			log.Println("[JIT] got stop signal, stopped jit")
+			st := jit.Stats()
+			log.Printf("[JIT] stopped, queued: %d, compiled: %d, failed: %d, bailed out: %d, compile time: %s\n",
+				st.Queued, st.Compiled, st.Failed, st.BailedOut, st.CompileTime)
 		}
 		return
 	}
@@ -137,9 +259,12 @@ func main() {
 			continue
 		}
 
+		if debug != nil {
+			debug.start(rl, input)
+		}
 		result, err := f.Eval()
 		if err != nil {
-			log.Println("Error:", err)
+			log.Printf("Error: %+v\n", err)
 			continue
 		}
 
@@ -1,32 +1,15 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"math"
 
-	"github.com/hneemann/parser2/funcGen"
 	"github.com/hneemann/parser2/value"
 )
 
 func main() {
 	parser := value.New()
-	parser.AddStaticFunction("pow", funcGen.Function[value.Value]{
-		Args: 2,
-		Func: func(st funcGen.Stack[value.Value], clsoureStore []value.Value) (value.Value, error) {
-			base, valid := st.Get(0).ToFloat()
-			if !valid {
-				return nil, errors.New("pow: base is not a number")
-			}
-
-			exp, valid := st.Get(1).ToFloat()
-			if !valid {
-				return nil, errors.New("pow: exponent is not a number")
-			}
-
-			return value.Float(math.Pow(base, exp)), nil
-		},
-	})
+	parser.AddNativeFunction("pow", math.Pow)
 	parser.AddSimpleFunction("double", func(val value.Value) value.Value {
 		num, _ := val.ToFloat()
 		return value.Float(num * 2)
@@ -0,0 +1,252 @@
+package funcGen
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/hneemann/parser2"
+)
+
+// DebugHook is called before and after the function created from a node
+// of the AST is evaluated. It is used to implement debuggers.
+type DebugHook[V any] interface {
+	// Before is called before the node is evaluated. If an error is
+	// returned, the node is not evaluated and the error is returned instead.
+	Before(e *DebugEvent[V]) error
+	// After is called after the node is evaluated. It is also called if
+	// Before has returned an error.
+	After(e *DebugEvent[V], value V, err error)
+}
+
+// DebugEvent describes the evaluation of a node
+type DebugEvent[V any] struct {
+	// Node is the evaluated node
+	Node parser2.AST
+	// Call is set if the node is the body of a closure, which means that
+	// the node is evaluated because the closure is called
+	Call *parser2.ClosureLiteral
+	// Stack is the stack the node is evaluated with
+	Stack Stack[V]
+	// ClosureStore holds the outer values accessed by a closure
+	ClosureStore []V
+	scope        *debugScope
+}
+
+// debugScope holds the variables visible to a node
+type debugScope struct {
+	am argsMap
+	cm argsMap
+}
+
+// Variables returns the sorted names of the variables visible to the node.
+// These are the arguments of the function, the variables declared by let
+// and the outer values used by a closure.
+func (e *DebugEvent[V]) Variables() []string {
+	var names []string
+	for n, i := range e.scope.am {
+		if i < e.Stack.Size() {
+			names = append(names, n)
+		}
+	}
+	for n, i := range e.scope.cm {
+		if i < len(e.ClosureStore) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Variable returns the value of the variable with the given name
+func (e *DebugEvent[V]) Variable(name string) (V, bool) {
+	if i, ok := e.scope.am[name]; ok && i < e.Stack.Size() {
+		return e.Stack.Get(i), true
+	}
+	if i, ok := e.scope.cm[name]; ok && i < len(e.ClosureStore) {
+		return e.ClosureStore[i], true
+	}
+	var zero V
+	return zero, false
+}
+
+// SetDebugHook sets the hook which is called before and after each node
+// is evaluated. If a hook is set, the AST is not optimized and functions
+// are not jit compiled, so that the hook sees all
+// nodes of the source.
+func (g *FunctionGenerator[V]) SetDebugHook(hook DebugHook[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.debugHook = hook
+	return g
+}
+
+// debug wraps the given function so that the debug hook is called
+func (g *FunctionGenerator[V]) debug(f ParserFunc[V], ast parser2.AST, gc GeneratorContext) ParserFunc[V] {
+	hook := g.debugHook
+	call := gc.call
+	scope := &debugScope{am: gc.am, cm: gc.cm}
+	return func(st Stack[V], cs []V) (V, error) {
+		e := &DebugEvent[V]{Node: ast, Call: call, Stack: st, ClosureStore: cs, scope: scope}
+		if err := hook.Before(e); err != nil {
+			var zero V
+			hook.After(e, zero, err)
+			return zero, err
+		}
+		v, err := f(st, cs)
+		hook.After(e, v, err)
+		return v, err
+	}
+}
+
+// ErrDebugAbort is returned if the evaluation is aborted by the debugger
+var ErrDebugAbort = errors.New("evaluation aborted by the debugger")
+
+// DebugCommand tells the debugger how to continue after it has stopped
+type DebugCommand int
+
+const (
+	// DebugContinue continues until a breakpoint is reached
+	DebugContinue DebugCommand = iota
+	// DebugStepInto stops at the next line, also if it is in a called closure
+	DebugStepInto
+	// DebugStepOver stops at the next line which is not in a called closure
+	DebugStepOver
+	// DebugStepOut stops at the next line after the current closure has returned
+	DebugStepOut
+	// DebugAbort aborts the evaluation with ErrDebugAbort
+	DebugAbort
+)
+
+// Debugger is a DebugHook which stops the evaluation at line breakpoints
+// and allows to step through the source. If the evaluation is stopped, the
+// stopped function is called. It returns the command which tells the
+// debugger how to continue. The calls of the hook are serialized, so the
+// debugger can be used with concurrent evaluations, but the call depth of
+// concurrent evaluations is not tracked separately.
+type Debugger[V any] struct {
+	mutex       sync.Mutex
+	stopMutex   sync.Mutex
+	stopped     func(e *DebugEvent[V], depth int) DebugCommand
+	breakpoints map[parser2.Line]bool
+	command     DebugCommand
+	// stepDepth is the call depth the last step was started at
+	stepDepth int
+	// lines holds the current line of each active call
+	lines []parser2.Line
+}
+
+// NewDebugger creates a new debugger. The given function is called if the
+// evaluation is stopped. It receives the node at which the evaluation is
+// stopped and the call depth, which is zero outside of closures.
+func NewDebugger[V any](stopped func(e *DebugEvent[V], depth int) DebugCommand) *Debugger[V] {
+	return &Debugger[V]{
+		stopped:     stopped,
+		breakpoints: map[parser2.Line]bool{},
+		lines:       []parser2.Line{0},
+	}
+}
+
+// SetBreakpoint sets a breakpoint at the given line
+func (d *Debugger[V]) SetBreakpoint(line parser2.Line) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.breakpoints[line] = true
+}
+
+// ClearBreakpoint removes the breakpoint at the given line
+func (d *Debugger[V]) ClearBreakpoint(line parser2.Line) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.breakpoints, line)
+}
+
+// Breakpoints returns the sorted lines of the breakpoints
+func (d *Debugger[V]) Breakpoints() []parser2.Line {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var lines []parser2.Line
+	for l := range d.breakpoints {
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// Reset prepares the debugger for a new evaluation. After a reset, the
+// evaluation runs until a breakpoint is reached.
+func (d *Debugger[V]) Reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.command = DebugContinue
+	d.stepDepth = 0
+	d.lines = []parser2.Line{0}
+}
+
+// Break stops the evaluation at the next line which is reached
+func (d *Debugger[V]) Break() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.command = DebugStepInto
+}
+
+// Before implements the DebugHook interface
+func (d *Debugger[V]) Before(e *DebugEvent[V]) error {
+	depth, stop := d.enter(e)
+	if !stop {
+		return nil
+	}
+
+	// the stopped function is called without holding the mutex, so that
+	// it is able to modify the breakpoints
+	d.stopMutex.Lock()
+	defer d.stopMutex.Unlock()
+	command := d.stopped(e, depth)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stepDepth = depth
+	if command == DebugAbort {
+		d.command = DebugContinue
+		return ErrDebugAbort
+	}
+	d.command = command
+	return nil
+}
+
+// enter tracks the line and the call depth of the evaluation and returns
+// true if the evaluation needs to be stopped
+func (d *Debugger[V]) enter(e *DebugEvent[V]) (int, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if e.Call != nil {
+		d.lines = append(d.lines, 0)
+	}
+	depth := len(d.lines) - 1
+	line := e.Node.GetLine()
+	if line == 0 || line == d.lines[depth] {
+		return depth, false
+	}
+	d.lines[depth] = line
+
+	stop := d.breakpoints[line]
+	switch d.command {
+	case DebugStepInto:
+		stop = true
+	case DebugStepOver:
+		stop = stop || depth <= d.stepDepth
+	case DebugStepOut:
+		stop = stop || depth < d.stepDepth
+	}
+	return depth, stop
+}
+
+// After implements the DebugHook interface
+func (d *Debugger[V]) After(e *DebugEvent[V], _ V, _ error) {
+	if e.Call != nil {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if len(d.lines) > 1 {
+			d.lines = d.lines[:len(d.lines)-1]
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package funcGen
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hneemann/parser2"
+	"github.com/stretchr/testify/assert"
+)
+
+const debugScript = `let f=x->
+  x*2;
+let y=f(a)+1;
+y*3`
+
+type debugStop struct {
+	line  parser2.Line
+	depth int
+	vars  []string
+}
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakpoints []parser2.Line
+		command     DebugCommand
+		want        []debugStop
+	}{
+		{name: "stepInto", command: DebugStepInto, want: []debugStop{
+			{line: 1, depth: 0, vars: []string{"a"}},
+			{line: 3, depth: 0, vars: []string{"a", "f"}},
+			{line: 2, depth: 1, vars: []string{"x"}},
+			{line: 4, depth: 0, vars: []string{"a", "f", "y"}},
+		}},
+		{name: "stepOver", command: DebugStepOver, want: []debugStop{
+			{line: 1, depth: 0, vars: []string{"a"}},
+			{line: 3, depth: 0, vars: []string{"a", "f"}},
+			{line: 4, depth: 0, vars: []string{"a", "f", "y"}},
+		}},
+		{name: "stepOut", command: DebugStepOut, want: []debugStop{
+			{line: 1, depth: 0, vars: []string{"a"}},
+		}},
+		{name: "breakpoint", breakpoints: []parser2.Line{2, 4}, command: DebugContinue, want: []debugStop{
+			{line: 2, depth: 1, vars: []string{"x"}},
+			{line: 4, depth: 0, vars: []string{"a", "f", "y"}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stops []debugStop
+			d := NewDebugger[Value](func(e *DebugEvent[Value], depth int) DebugCommand {
+				stops = append(stops, debugStop{line: e.Node.GetLine(), depth: depth, vars: e.Variables()})
+				return test.command
+			})
+			for _, b := range test.breakpoints {
+				d.SetBreakpoint(b)
+			}
+			if test.breakpoints == nil {
+				d.Break()
+			}
+			f, err := NewGen().SetDebugHook(d).Generate(debugScript, "a")
+			assert.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				stops = nil
+				r, err := f.Eval(Float(2))
+				assert.NoError(t, err)
+				assert.Equal(t, Float(15), r)
+				assert.Equal(t, test.want, stops)
+
+				d.Reset()
+				if test.breakpoints == nil {
+					d.Break()
+				}
+			}
+		})
+	}
+}
+
+func TestDebuggerVariables(t *testing.T) {
+	values := map[string]Value{}
+	d := NewDebugger[Value](func(e *DebugEvent[Value], depth int) DebugCommand {
+		for _, n := range e.Variables() {
+			values[n], _ = e.Variable(n)
+		}
+		_, ok := e.Variable("z")
+		assert.False(t, ok)
+		return DebugStepInto
+	})
+	d.Break()
+	f, err := NewGen().SetDebugHook(d).Generate(debugScript, "a")
+	assert.NoError(t, err)
+	_, err = f.Eval(Float(2))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(2), values["a"])
+	assert.Equal(t, Float(2), values["x"])
+	assert.Equal(t, Float(5), values["y"])
+}
+
+func TestDebuggerAbort(t *testing.T) {
+	d := NewDebugger[Value](func(e *DebugEvent[Value], depth int) DebugCommand {
+		return DebugAbort
+	})
+	d.SetBreakpoint(2)
+	f, err := NewGen().SetDebugHook(d).Generate(debugScript, "a")
+	assert.NoError(t, err)
+	_, err = f.Eval(Float(2))
+	assert.True(t, errors.Is(err, ErrDebugAbort), err)
+
+	assert.Equal(t, []parser2.Line{2}, d.Breakpoints())
+	d.ClearBreakpoint(2)
+	d.Reset()
+	r, err := f.Eval(Float(2))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(15), r)
+}
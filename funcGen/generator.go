@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
 	"reflect"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -14,16 +16,22 @@ import (
 	"github.com/hneemann/parser2/listMap"
 )
 
-// JIT_CONSTANT sets the threshold the function invocation meta tracing counter
-// has to pass for the function to be considered hot and thus compilable. The value
-// was determined by benchmarks concluded in "Comparing a Tree-walk Interpreter
-// with JIT compilation and embedding via Go-plugins" [1]:
+// DefaultJitThreshold is the default number of calls a function needs to
+// pass to be considered hot and thus compilable. The value was determined by
+// benchmarks concluded in "Comparing a Tree-walk Interpreter with JIT
+// compilation and embedding via Go-plugins" [1]:
 //
 // [1]: https://github.com/xNaCly/treewalk-vs-jit-with-go-plugins
-var JIT_CONSTANT int = 10_000
+const DefaultJitThreshold = 10_000
 
 type stackStorage[V any] struct {
 	data []V
+	// limit is set if the evaluation is started by EvalContext
+	limit *evalLimit
+	// profile is set if the evaluation is profiled
+	profile *profileState
+	// state holds the values stored by EvalState, it is created on demand
+	state atomic.Pointer[evalState]
 }
 
 func (s *stackStorage[V]) set(n int, v V) {
@@ -209,23 +217,21 @@ type Function[V any] struct {
 
 	// jit specific data
 
-	// Counter stores the amount of calls made to the function
-	Counter int
 	// Ast stores the abstract syntax tree, used for jit compilation of the given function
 	Ast parser2.AST
-	// Func is the function itself, but compiled to machine code by the jit compiler
-	JitFunc func(...any) (V, error)
 	// JitCompiler holds a pointer to the compiler the function invokes once
-	// the JIT_CONSTANT threshold is reached
+	// the jit threshold is reached
 	JitCompiler *Jit[V]
 	// ArgumentNames contains the list of parameter names of the function
 	ArgumentNames []string
 	// Name holds the name of the function
 	Name string
-	// MetaData holds the necessary data for the jit to compile valid functions
+	// MetaData holds the necessary data for the jit to compile valid functions.
+	// It is only set in the copy of the function which is passed to the jit.
 	MetaData *MetaData
-	// wasJit is true if the function was jit compiled
-	wasJit bool
+	// state counts the calls and holds the compiled function. It is shared
+	// by all copies of the function. If nil, the function is never compiled.
+	state *jitState[V]
 }
 
 func (f Function[V]) SetMethodDescription(descr ...string) Function[V] {
@@ -254,73 +260,65 @@ func (f Function[V]) SetDescription(descr ...string) Function[V] {
 // The stack [st] is used to pass the given argument [a] to the function.
 // The pushed value is removed after the function is called.
 func (f *Function[V]) Eval(st Stack[V], a V) (V, error) {
-	if f.wasJit && f.JitFunc != nil {
-		out, err := f.JitFunc(f.JitCompiler.ValueToUnderlying(a))
-		// compiled function had a panic, throwing compiled function away and
-		// bailing out to the interpreter
-		if err != nil {
-			f.JitFunc = nil
-			f.wasJit = false
-			f.Counter = 0
-			goto bailout
-		}
-		return out, nil
-	}
-
-	if !f.wasJit && f.Counter >= JIT_CONSTANT && f.JitCompiler != nil && f.Ast != nil {
-		f.wasJit = true
-		f.MetaData = &MetaData{
-			Parameters: []MetaDataParameter{
-				{Name: f.ArgumentNames[0], Type: f.JitCompiler.TypeToString(a)},
-			},
-		}
-		f.JitCompiler.Queue <- f
-	}
-
-bailout:
-	f.Counter++
 	st.Push(a)
-	return f.Func(st.CreateFrame(1), nil)
+	return f.callFrame(st.CreateFrame(1), nil)
 }
 
 // EvalSt is used to evaluate a function with multiple arguments
 // The stack [st] is used to pass the given arguments to the function.
 // The pushed values are removed after the function is called.
 func (f *Function[V]) EvalSt(st Stack[V], a ...V) (V, error) {
-	if f.wasJit && f.JitFunc != nil {
-		args := make([]any, len(a))
-		for i, e := range a {
-			args[i] = f.JitCompiler.ValueToUnderlying(e)
-		}
+	for _, e := range a {
+		st.Push(e)
+	}
+	return f.callFrame(st.CreateFrame(len(a)), nil)
+}
 
-		out, err := f.JitFunc(args...)
-		// compiled function had a panic, throwing compiled function away and
-		// bailing out to the interpreter
-		if err != nil {
-			f.JitFunc = nil
-			f.wasJit = false
-			f.Counter = 0
-			goto bailout
+// callFrame calls the function with the arguments in the given frame.
+// The call is counted, so that the function can become hot, also if it is
+// called directly by the script. If there is a compiled variant matching
+// the arguments, it is used instead of the interpreter.
+func (f *Function[V]) callFrame(frame Stack[V], cs []V) (V, error) {
+	if l := frame.limit(); l != nil {
+		return f.callLimited(l, frame, cs)
+	}
+	if s := f.state; s != nil {
+		if s.hasVariants() {
+			args := make([]any, frame.Size())
+			for i := range args {
+				args[i] = f.JitCompiler.ValueToUnderlying(frame.Get(i))
+			}
+			if out, ok := s.run(frame, args...); ok {
+				return out, nil
+			}
+		}
+		if s.call(f) {
+			f.queue(frame.ToSlice()...)
 		}
-		return out, nil
 	}
+	return f.Func(frame, cs)
+}
 
-	if !f.wasJit && f.Counter >= JIT_CONSTANT && f.JitCompiler != nil && f.Ast != nil {
-		f.wasJit = true
-		params := make([]MetaDataParameter, len(a))
-		for i, e := range a {
-			params[i] = MetaDataParameter{Name: f.ArgumentNames[i], Type: f.JitCompiler.TypeToString(e)}
-		}
-		f.MetaData = &MetaData{Parameters: params}
-		f.JitCompiler.Queue <- f
+// queue passes a copy of the function to the jit compiler. The copy holds
+// the types of the given arguments.
+func (f *Function[V]) queue(a ...V) {
+	params := make([]MetaDataParameter, len(a))
+	for i, e := range a {
+		params[i] = MetaDataParameter{Name: f.ArgumentNames[i], Type: f.JitCompiler.TypeToString(e)}
 	}
+	c := *f
+	c.MetaData = &MetaData{Parameters: params}
+	f.JitCompiler.enqueue(&c)
+}
 
-bailout:
-	f.Counter++
-	for _, e := range a {
-		st.Push(e)
+// JitFunctions returns the jit compiled variants of the function. Each
+// variant is compiled for different argument types. If called with
+// arguments of other types, a variant returns an error.
+func (f *Function[V]) JitFunctions() []func(...any) (V, error) {
+	if f.state == nil {
+		return nil
 	}
-	return f.Func(st.CreateFrame(len(a)), nil)
+	return f.state.compiled()
 }
 
 func (f Function[V]) argsNumberNotMatching(available int) bool {
@@ -358,10 +356,22 @@ type ClosureHandler[V any] interface {
 	ToClosure(c V) (Function[V], bool)
 }
 
+// Memoizer is used to create memoized functions
+type Memoizer[V any] interface {
+	// Memoize returns a function which caches the results of the given function.
+	// It is called every time a closure declared by 'memo func' is created,
+	// so every closure instance gets its own cache.
+	Memoize(f Function[V]) Function[V]
+}
+
 // MethodHandler is used to give access to methods.
 type MethodHandler[V any] interface {
 	// GetMethod is used to get a method on a value.
 	// The value is the first argument at calling the function.
+	// The IsPure flag of the returned function reports whether the method
+	// is pure for the type of the given value. Pure methods called on
+	// constants are evaluated by the optimizer. If such a call takes more
+	// than a few thousand steps, it is left to the evaluation.
 	GetMethod(value V, methodName string) (Function[V], error)
 }
 
@@ -372,7 +382,7 @@ func (mh MethodHandlerFunc[V]) GetMethod(value V, methodName string) (Function[V
 }
 
 type LetPostOptimizer[V any] interface {
-	OptimizePostLetEval(value V)
+	OptimizePostLetEval(st Stack[V], value V)
 }
 
 // Generator is used to define a customized generation of functions
@@ -395,12 +405,14 @@ type FunctionGenerator[V any] struct {
 	parser           *parser2.Parser[V]
 	operators        []Operator[V]
 	jit              *Jit[V]
+	threshold        int
 	unary            []UnaryOperator[V]
 	numberParser     parser2.NumberParser[V]
 	stringHandler    parser2.StringConverter[V]
 	listHandler      ListHandler[V]
 	mapHandler       MapHandler[V]
 	closureHandler   ClosureHandler[V]
+	memoizer         Memoizer[V]
 	methodHandler    MethodHandler[V]
 	letPostOptimizer LetPostOptimizer[V]
 	optimizer        parser2.Optimizer
@@ -412,6 +424,13 @@ type FunctionGenerator[V any] struct {
 	uMap             map[string]UnaryOperator[V]
 	customGenerator  Generator[V]
 	finalizer        func(g *FunctionGenerator[V])
+	panicHandling    PanicHandling
+	debugHook        DebugHook[V]
+	profiler         *Profiler
+	policy           *Policy
+	// mutex serializes the generation of functions
+	mutex  sync.Mutex
+	frozen atomic.Bool
 }
 
 // New creates a new FunctionGenerator
@@ -420,6 +439,7 @@ func New[V any]() *FunctionGenerator[V] {
 		constants:       constMap[V]{},
 		staticFunctions: make(map[string]Function[V]),
 		methodHandler:   MethodHandlerFunc[V](methodByReflection[V]),
+		threshold:       DefaultJitThreshold,
 	}
 	g.optimizer = NewOptimizer(NewEmptyStack[V](), g)
 	return g
@@ -433,25 +453,26 @@ func (g *FunctionGenerator[V]) SetNumberParser(numberParser parser2.NumberParser
 	return g
 }
 
+// SetJitThreshold sets the number of calls after which a function is
+// considered hot. Hot functions are compiled by the jit.
+func (g *FunctionGenerator[V]) SetJitThreshold(threshold int) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.threshold = threshold
+	return g
+}
+
 func (g *FunctionGenerator[V]) SetJit() *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	ctx, cancel := context.WithCancel(context.Background())
 	g.jit = &Jit[V]{
-		Queue:  make(chan *Function[V], 16),
-		Ctx:    ctx,
-		Cancel: cancel,
-	}
-	go func() {
-		for {
-			select {
-			case f := <-g.jit.Queue:
-				if err := g.jit.Compile(f); err != nil {
-					log.Println("[JIT] compilation failed, skipping this function", err)
-				}
-			case <-g.jit.Ctx.Done():
-				return
-			}
-		}
-	}()
+		Queue:        make(chan *Function[V], 16),
+		Ctx:          ctx,
+		Cancel:       cancel,
+		BatchWindow:  50 * time.Millisecond,
+		MaxBatchSize: 16,
+		g:            g,
+	}
+	go g.jit.worker()
 	return g
 }
 
@@ -468,36 +489,49 @@ func (g *FunctionGenerator[V]) SetStringConverter(stringConverter parser2.String
 }
 
 func (g *FunctionGenerator[V]) SetListHandler(listHandler ListHandler[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.listHandler = listHandler
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetMapHandler(mapHandler MapHandler[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.mapHandler = mapHandler
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetMethodHandler(methodHandler MethodHandler[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.methodHandler = methodHandler
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetLetPostOptimizer(letPostOptimizer LetPostOptimizer[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.letPostOptimizer = letPostOptimizer
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetClosureHandler(closureHandler ClosureHandler[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.closureHandler = closureHandler
 	return g
 }
 
+func (g *FunctionGenerator[V]) SetMemoizer(memoizer Memoizer[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.memoizer = memoizer
+	return g
+}
+
 func (g *FunctionGenerator[V]) SetToBool(toBool ToBool[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.toBool = toBool
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetIsEqual(isEqual BoolFunc[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.isEqual = isEqual
 	return g
 }
@@ -573,6 +607,7 @@ func (g *FunctionGenerator[V]) GetOpImpl(name string) func(st Stack[V], a, b V)
 }
 
 func (g *FunctionGenerator[V]) AddConstant(n string, c V) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.constants[n] = c
 	return g
 }
@@ -594,21 +629,25 @@ func (g *FunctionGenerator[V]) AddGoFunction(name string, args int, f func(a ...
 }
 
 func (g *FunctionGenerator[V]) AddStaticFunction(n string, f Function[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.staticFunctions[n] = f
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetOptimizer(optimizer parser2.Optimizer) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.optimizer = optimizer
 	return g
 }
 
 func (g *FunctionGenerator[V]) SetCustomGenerator(generator Generator[V]) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	g.customGenerator = generator
 	return g
 }
 
 func (g *FunctionGenerator[V]) AddFinalizer(finalizer func(*FunctionGenerator[V])) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
 	if g.finalizer == nil {
 		g.finalizer = finalizer
 	} else {
@@ -676,6 +715,8 @@ type GeneratorContext struct {
 	am       argsMap
 	cm       argsMap
 	ThisName string
+	// call is the closure whose body is generated, only used by the debug hook
+	call *parser2.ClosureLiteral
 }
 
 func (c GeneratorContext) addLocalVar(name string) (GeneratorContext, error) {
@@ -692,20 +733,63 @@ func (f Func[V]) Eval(args ...V) (V, error) {
 	return f(NewEmptyStack[V]().Init(args...))
 }
 
+// Generate creates a function from the given expression. It is safe to call
+// Generate from several goroutines. The first call freezes the generator.
+// The created function can be evaluated concurrently.
 func (g *FunctionGenerator[V]) Generate(exp string, args ...string) (Func[V], error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 	return g.generateIntern(args, exp, "")
 }
 
 func (g *FunctionGenerator[V]) GenerateWithMap(exp string, mapName string) (Func[V], error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 	return g.generateIntern([]string{mapName}, exp, mapName)
 }
 
-func (g *FunctionGenerator[V]) generateIntern(args []string, exp string, ThisName string) (Func[V], error) {
+// Freeze completes the configuration of the generator. The finalizers are
+// called and the parser is created. After that, the generator can not be
+// modified anymore, which allows to generate functions concurrently.
+// Calling Freeze is optional as the first call of Generate freezes the
+// generator, but it allows to detect configuration errors early.
+func (g *FunctionGenerator[V]) Freeze() *FunctionGenerator[V] {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.freeze()
+	return g
+}
+
+// IsFrozen returns true if the generator is frozen
+func (g *FunctionGenerator[V]) IsFrozen() bool {
+	return g.frozen.Load()
+}
+
+func (g *FunctionGenerator[V]) freeze() {
+	if !g.frozen.Load() {
+		g.runFinalizer()
+		g.GetParser()
+		g.frozen.Store(true)
+	}
+}
+
+// CheckNotFrozen panics if the generator is frozen. It is called by all
+// methods which modify the generator.
+func (g *FunctionGenerator[V]) CheckNotFrozen() {
+	if g.frozen.Load() {
+		panic("generator is frozen")
+	}
+}
+
+// runFinalizer calls the finalizer if not already done
+func (g *FunctionGenerator[V]) runFinalizer() {
 	if g.finalizer != nil {
 		g.finalizer(g)
 		g.finalizer = nil
 	}
+}
 
+func (g *FunctionGenerator[V]) generateIntern(args []string, exp string, ThisName string) (Func[V], error) {
 	ast, err := g.CreateAst(exp)
 	if err != nil {
 		return nil, err
@@ -727,16 +811,26 @@ func (g *FunctionGenerator[V]) generateIntern(args []string, exp string, ThisNam
 	if err != nil {
 		return nil, err
 	}
+	if g.panicHandling == PropagatePanics {
+		return func(st Stack[V]) (V, error) {
+			v, err := f(st, nil)
+			if err != nil {
+				err = mainFrame(err)
+			}
+			return v, err
+		}, nil
+	}
 	return func(st Stack[V]) (val V, err error) {
-		// TODO:
-		// defer func() {
-		// 	if rec := recover(); rec != nil {
-		// 		log.Print("panic in function: ", rec)
-		// 		var zero V
-		// 		val = zero
-		// 		err = parser2.AnyToError(rec)
-		// 	}
-		// }()
+		defer func() {
+			if rec := recover(); rec != nil {
+				var zero V
+				val = zero
+				err = g.panicError(rec, "", 0)
+			}
+			if err != nil {
+				err = mainFrame(err)
+			}
+		}()
 		return f(st, nil)
 	}, nil
 }
@@ -745,12 +839,17 @@ func (g *FunctionGenerator[V]) generateIntern(args []string, exp string, ThisNam
 // This method is public manly to inspect the AST in tests that live outside
 // this package.
 func (g *FunctionGenerator[V]) CreateAst(exp string) (parser2.AST, error) {
+	g.freeze()
 	ast, err := g.GetParser().Parse(exp)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing expression: %w", err)
 	}
+	err = g.checkPolicy(ast)
+	if err != nil {
+		return nil, err
+	}
 
-	if g.optimizer != nil {
+	if g.optimizer != nil && g.debugHook == nil {
 		ast, err = parser2.Optimize(ast, g.optimizer)
 		if err != nil {
 			return nil, err
@@ -760,6 +859,43 @@ func (g *FunctionGenerator[V]) CreateAst(exp string) (parser2.AST, error) {
 }
 
 func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext) (ParserFunc[V], error) {
+	if !g.instrumented() {
+		return g.generateFunc(ast, gc)
+	}
+	call := gc.call
+	gc.call = nil
+	if g.profiler != nil {
+		g.profiler.nameClosure(ast)
+	}
+	f, err := g.generateFunc(ast, gc)
+	if err != nil {
+		return nil, err
+	}
+	if g.profiler != nil {
+		switch ast.(type) {
+		case *parser2.Const[V], *parser2.Ident:
+		default:
+			f = g.profile(f, ast, nil)
+		}
+		if call != nil {
+			f = g.profile(f, ast, call)
+		}
+	}
+	if g.debugHook != nil {
+		gc.call = call
+		f = g.debug(f, ast, gc)
+	}
+	return f, nil
+}
+
+// instrumented returns true if the generated functions are wrapped by
+// a debug hook or a profiler. In this case, functions are not
+// jit compiled.
+func (g *FunctionGenerator[V]) instrumented() bool {
+	return g.debugHook != nil || g.profiler != nil
+}
+
+func (g *FunctionGenerator[V]) generateFunc(ast parser2.AST, gc GeneratorContext) (ParserFunc[V], error) {
 	var zero V
 	if g.customGenerator != nil {
 		c, err := g.customGenerator.GenerateCustom(ast, gc, g)
@@ -843,7 +979,7 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 			}
 
 			if g.letPostOptimizer != nil {
-				g.letPostOptimizer.OptimizePostLetEval(va)
+				g.letPostOptimizer.OptimizePostLetEval(st, va)
 			}
 
 			st.Push(va)
@@ -961,7 +1097,11 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 			if err != nil {
 				return zero, a.EnhanceErrorf(err, "error in unary %v", a.Operator)
 			}
-			return op(v)
+			v, err = op(v)
+			if err != nil {
+				return zero, atLine(err, a.Line)
+			}
+			return v, nil
 		}, nil
 	case *parser2.Operate:
 		aFunc, err := g.GenerateFunc(a.A, gc)
@@ -982,9 +1122,16 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 			if err != nil {
 				return zero, a.EnhanceErrorf(err, "error in operation %v", a.Operator)
 			}
-			return op(st, aVal, bVal)
+			v, err := op(st, aVal, bVal)
+			if err != nil {
+				return zero, atLine(err, a.Line)
+			}
+			return v, nil
 		}, nil
 	case *parser2.ClosureLiteral:
+		if a.Memo && g.memoizer == nil {
+			return nil, a.Errorf("memoized functions are not supported")
+		}
 		funcArgs := argsMap{}
 		for _, arg := range a.Names {
 			err := funcArgs.add(arg)
@@ -996,23 +1143,22 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 		// INFO: compiling closures
 		if len(usedVars) == 0 {
 			// not a closure, just a function
-			closureFunc, err := g.GenerateFunc(a.Func, GeneratorContext{am: funcArgs})
+			closureFunc, err := g.GenerateFunc(a.Func, GeneratorContext{am: funcArgs, call: a})
 			if err != nil {
 				return nil, err
 			}
-			args := make([]string, 0, len(a.Names))
-			for k := range funcArgs {
-				args = append(args, k)
-			}
+			closureFunc = traced(closureFunc, a, a.Name)
+			// functions without captured values share their state
+			state := g.newJitState()
 			return func(st Stack[V], cs []V) (V, error) {
-				return g.closureHandler.FromClosure(Function[V]{
+				return g.fromClosureLiteral(a, Function[V]{
 					Name:          a.Name,
 					Func:          closureFunc,
-					ArgumentNames: args,
+					ArgumentNames: a.Names,
 					Args:          len(a.Names),
 					Ast:           a,
 					JitCompiler:   g.jit,
-					Counter:       0,
+					state:         state,
 				}), nil
 			}, nil
 		} else {
@@ -1118,7 +1264,7 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 						}
 						st.Push(v)
 					}
-					return fun.Func(st.CreateFrame(len(argsFuncList)), nil)
+					return g.callGo(fun.Func, st.CreateFrame(len(argsFuncList)), id.Name, a.Line)
 				}, nil
 			}
 		}
@@ -1149,7 +1295,11 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 				}
 				st.Push(v)
 			}
-			return theFunc.Func(st.CreateFrame(len(argsFuncList)), cs)
+			v, err := theFunc.callFrame(st.CreateFrame(len(argsFuncList)), cs)
+			if err != nil {
+				return zero, atLine(err, a.Line)
+			}
+			return v, nil
 		}, nil
 	case *parser2.MethodCall:
 		valFunc, err := g.GenerateFunc(a.Value, gc)
@@ -1181,7 +1331,11 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 							}
 							st.Push(v)
 						}
-						return theFunc.Func(st.CreateFrame(len(argsFuncList)), cs)
+						v, err := theFunc.callFrame(st.CreateFrame(len(argsFuncList)), cs)
+						if err != nil {
+							return zero, atLine(err, a.Line)
+						}
+						return v, nil
 					}
 				}
 			}
@@ -1201,7 +1355,7 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 					}
 					st.Push(v)
 				}
-				return me.Func(st.CreateFrame(len(argsFuncList)+1), nil)
+				return g.callGo(me.Func, st.CreateFrame(len(argsFuncList)+1), name, a.Line)
 			}
 			return zero, a.Errorf("method %s not found", name)
 		}, nil
@@ -1210,6 +1364,10 @@ func (g *FunctionGenerator[V]) GenerateFunc(ast parser2.AST, gc GeneratorContext
 }
 
 func (g *FunctionGenerator[V]) createClosureLiteralFunc(a *parser2.ClosureLiteral, innerContext GeneratorContext, gc GeneratorContext, recursiveName string) (ParserFunc[V], error) {
+	if a.Memo && g.memoizer == nil {
+		return nil, a.Errorf("memoized functions are not supported")
+	}
+	innerContext.call = a
 	closureFunc, err := g.GenerateFunc(a.Func, innerContext)
 	if err != nil {
 		return nil, err
@@ -1232,18 +1390,20 @@ func (g *FunctionGenerator[V]) createClosureLiteralFunc(a *parser2.ClosureLitera
 			}
 		}
 	}
+	name := closureName(a, recursiveName)
+	closureFunc = traced(closureFunc, a, name)
 	return func(st Stack[V], cs []V) (V, error) {
 		closureContext := make([]V, len(accessContextOperations))
-		closure := g.closureHandler.FromClosure(Function[V]{
+		closure := g.fromClosureLiteral(a, Function[V]{
 			Func: func(st Stack[V], cs []V) (V, error) {
 				return closureFunc(st, closureContext)
 			},
-			Name:          a.Name,
+			Name:          name,
 			Args:          len(a.Names),
 			ArgumentNames: a.Names,
 			Ast:           a,
 			JitCompiler:   g.jit,
-			Counter:       0,
+			state:         g.newJitState(),
 		})
 		for i, accessContext := range accessContextOperations {
 			closureContext[i] = accessContext(st, cs, closure)
@@ -1252,6 +1412,25 @@ func (g *FunctionGenerator[V]) createClosureLiteralFunc(a *parser2.ClosureLitera
 	}, nil
 }
 
+// closureName returns the name of a closure. A closure assigned to a
+// variable by let is named by the variable if it calls itself.
+func closureName(a *parser2.ClosureLiteral, recursiveName string) string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return recursiveName
+}
+
+// fromClosureLiteral converts the function created from the given closure
+// literal to a value. If the closure is declared by 'memo func', the function
+// is memoized before it is converted.
+func (g *FunctionGenerator[V]) fromClosureLiteral(a *parser2.ClosureLiteral, f Function[V]) V {
+	if a.Memo {
+		f = g.memoizer.Memoize(f)
+	}
+	return g.closureHandler.FromClosure(f)
+}
+
 func (g *FunctionGenerator[V]) genFuncList(a []parser2.AST, gc GeneratorContext) ([]ParserFunc[V], error) {
 	args := make([]ParserFunc[V], len(a))
 	for i, arg := range a {
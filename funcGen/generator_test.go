@@ -9,6 +9,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -269,3 +270,53 @@ func TestFunctionDescription_String(t *testing.T) {
 		})
 	}
 }
+
+func TestFreeze(t *testing.T) {
+	fg := NewGen()
+	finalized := 0
+	fg.AddFinalizer(func(g *FunctionGenerator[Value]) {
+		finalized++
+		g.AddConstant("c", Float(2))
+	})
+	assert.False(t, fg.IsFrozen())
+	fg.Freeze().Freeze()
+	assert.True(t, fg.IsFrozen())
+	assert.Equal(t, 1, finalized)
+	assert.PanicsWithValue(t, "generator is frozen", func() {
+		fg.AddConstant("d", Float(1))
+	})
+	assert.PanicsWithValue(t, "generator is frozen", func() {
+		fg.AddSimpleFunction("sqr", func(v Value) Value { return v })
+	})
+
+	f, err := fg.Generate("a*c", "a")
+	assert.NoError(t, err)
+	res, err := f.Eval(Float(3))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(6), res)
+}
+
+func TestConcurrentGenerate(t *testing.T) {
+	fg := NewGen()
+	shared, err := fg.Generate("func f(x) x*x+1;\nf(a)+f(a*2)", "a")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, err := fg.Generate("a*(a+"+strconv.Itoa(i)+")", "a")
+			assert.NoError(t, err)
+			for j := 0; j < 100; j++ {
+				res, err := f.Eval(Float(2))
+				assert.NoError(t, err)
+				assert.Equal(t, Float(2*(2+i)), res)
+				res, err = shared.Eval(Float(10))
+				assert.NoError(t, err)
+				assert.Equal(t, Float(502), res)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
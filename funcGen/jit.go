@@ -5,21 +5,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"go/format"
+	"math"
 	"os/exec"
 	"plugin"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/hneemann/parser2"
 )
 
-// TODO:
-// - named functions are currently not being compiled
-
 // Jit implements just in time compilation of expressions, this requires a not
 // to neglect start up time and thus should only be invoked once the callee is
 // sure to outperform the interpreted expression using the compiled function.
@@ -36,8 +36,6 @@ type Jit[V any] struct {
 	Queue  chan *Function[V]
 	Ctx    context.Context
 	Cancel context.CancelFunc
-	// counter is used for compiling closures and keeping track of them in shared objects
-	counter uint64
 	// TypeToString is used to convert the given arguments type to a string
 	// representation the jit compiler uses to assert the function parameters
 	// type
@@ -46,12 +44,79 @@ type Jit[V any] struct {
 	ValueToUnderlying func(V) any
 	// UnderlyingToValue converts the argument to the generic type
 	UnderlyingToValue func(any) V
+	// CacheDir is the directory the compiled plugins are stored in. If set,
+	// plugins are reused across process runs. The cache key is a hash of the
+	// generated source, the go version, the argument types, the version of
+	// the host and CacheVersion.
+	CacheDir string
+	// CacheMaxSize is the maximum size of the cache in bytes. If exceeded, the
+	// least recently used plugins are removed. Zero means no limit.
+	CacheMaxSize int64
+	// CacheVersion is added to the cache key. Changing it invalidates all
+	// cached plugins, e.g. if the value conversions have changed.
+	CacheVersion string
+	// BatchWindow is the time the worker waits for further hot functions
+	// after the first one was queued. All functions collected are compiled
+	// into a single plugin.
+	BatchWindow time.Duration
+	// MaxBatchSize is the maximum number of functions compiled at once
+	MaxBatchSize int
+	// Synchronous compiles the functions in the calling goroutine instead
+	// of queueing them. This is mainly used for testing.
+	Synchronous bool
+	// OnEvent is called with the events of the jit, like compiled functions
+	// or compilation failures. It is called from the goroutine compiling
+	// the functions and must be set before any function is evaluated.
+	OnEvent func(JitEvent)
+	stats   jitStats[V]
+	// g is used by the runtime the compiled functions call back into
+	g *FunctionGenerator[V]
+}
+
+// worker compiles the queued functions until the context is cancelled
+func (j *Jit[V]) worker() {
+	for {
+		select {
+		case f := <-j.Queue:
+			// failures are reported by events, the functions are interpreted
+			j.CompileBatch(j.collect(f))
+		case <-j.Ctx.Done():
+			return
+		}
+	}
+}
+
+// collect collects the functions queued within the batch window
+func (j *Jit[V]) collect(first *Function[V]) []*Function[V] {
+	batch := []*Function[V]{first}
+	timer := time.NewTimer(j.BatchWindow)
+	defer timer.Stop()
+	for len(batch) < j.MaxBatchSize {
+		select {
+		case f := <-j.Queue:
+			batch = append(batch, f)
+		case <-timer.C:
+			return batch
+		case <-j.Ctx.Done():
+			return batch
+		}
+	}
+	return batch
 }
 
 // Invokes the code generation, traverses the abstract syntax tree, calls
 // the go compiler, opens the compiled plugin and returns the generated and
 // compiled function
 func (j *Jit[V]) Compile(fun *Function[V]) error {
+	return j.CompileBatch([]*Function[V]{fun})
+}
+
+// CompileBatch compiles several functions into a single plugin, so the
+// start-up cost of the go compiler is only paid once. Functions which can
+// not be translated are skipped. If the build of the plugin fails, the
+// functions are compiled one by one, so a single faulty function does not
+// prevent the others from being compiled.
+func (j *Jit[V]) CompileBatch(funs []*Function[V]) error {
 	start := time.Now()
 	if runtime.GOOS == "windows" {
 		return fmt.Errorf(`
@@ -59,208 +124,749 @@ The go plugin api is not supported on windows, just in time compilation is there
 See: https://pkg.go.dev/plugin#hdr-Warnings (%w)`, errors.ErrUnsupported)
 	}
 
-	f, err := os.CreateTemp("", "JIT_*.go")
-	defer os.Remove(f.Name())
-	if err != nil {
-		return err
-	}
 	b := bytes.Buffer{}
-	b.WriteString(`package main;`)
-	// logic for naming closures
-	if len(fun.Name) == 0 {
-		fun.Name = string([]byte{'c', byte(j.counter + '0')})
-		j.counter++
-	}
-	c := fun.Ast.(*parser2.ClosureLiteral)
-	c.Name = fun.Name
-	log.Printf("[JIT] attempting to compile %q\n", c.Name)
-	err = j.generateFunction(&b, c, fun.MetaData)
-	if err != nil {
-		return err
+	b.WriteString(jitPrelude)
+	var compiled []*Function[V]
+	var symbols []string
+	var meta []*MetaData
+	used := map[string]bool{}
+	var genErr error
+	for _, fun := range funs {
+		if fun.state == nil || fun.MetaData == nil {
+			genErr = fmt.Errorf("function %s can not be compiled", fun.jitName())
+			j.failed(JitSkipped, fun, genErr)
+			continue
+		}
+		c := fun.Ast.(*parser2.ClosureLiteral)
+		base := jitSymbol(fun.Name, c.Line)
+		symbol := base
+		for i := 1; used[symbol]; i++ {
+			symbol = base + "_" + strconv.Itoa(i)
+		}
+		fb := bytes.Buffer{}
+		err := j.generateFunction(&fb, symbol, c, fun.Name, fun.MetaData)
+		if err != nil {
+			j.failed(JitSkipped, fun, err)
+			genErr = err
+			continue
+		}
+		code, err := format.Source(fb.Bytes())
+		if err != nil {
+			// should not happen, the code generator has created invalid go code
+			err = fmt.Errorf("Codegen: invalid go code generated for %s: %w", fun.jitName(), err)
+			j.failed(JitSkipped, fun, err)
+			genErr = err
+			continue
+		}
+		used[symbol] = true
+		b.WriteString("\n")
+		b.Write(code)
+		compiled = append(compiled, fun)
+		symbols = append(symbols, symbol)
+		meta = append(meta, fun.MetaData)
 	}
-	log.Println("[JIT] compiled to:", b.String())
-	if _, err := b.WriteTo(f); err != nil {
-		return err
+	if len(compiled) == 0 {
+		return genErr
 	}
-	path, err := invokeCompiler(f.Name())
+	j.event(JitEvent{Kind: JitSource, Source: b.String()})
+	path, cleanup, err := j.build(b.Bytes(), meta)
 	if err != nil {
+		if len(compiled) > 1 {
+			// compile them one by one, the failures are reported by events
+			for _, fun := range compiled {
+				j.Compile(fun)
+			}
+			return nil
+		}
+		j.failed(JitFailed, compiled[0], err)
 		return err
 	}
-	defer os.Remove(path)
-	funct, err := j.function("JIT_"+fun.Name, path)
+	defer cleanup()
+	p, err := j.open(path)
 	if err != nil {
-		log.Printf("[JIT] failed to compile %s: %s\n", fun.Name, err)
+		for _, fun := range compiled {
+			j.failed(JitFailed, fun, err)
+		}
 		return err
 	}
-	fun.JitFunc = funct
-	log.Printf("[JIT] compiling %q took %s\n", c.Name, time.Since(start))
+	d := time.Since(start)
+	j.stats.compileTime.Add(int64(d))
+	for i, fun := range compiled {
+		funct, err := j.function(p, symbols[i])
+		if err != nil {
+			j.failed(JitFailed, fun, err)
+			return err
+		}
+		j.compiled(fun, fun.state.addVariant(funct), d)
+	}
 	return nil
 }
 
-// generateFunction generates the go code for a given closure recursively
-func (j *Jit[V]) generateFunction(b *bytes.Buffer, fun *parser2.ClosureLiteral, m *MetaData) error {
+// generateFunction generates the go code for a given closure recursively.
+// The entry of the function checks the types of the arguments. If they do
+// not match the types the function was compiled for, JIT_Deopt is returned.
+// If the function has a name, it can call itself. In this case the body is
+// generated as a separate typed go function, so that recursive calls whose
+// arguments have the matching types stay inside the compiled code.
+func (j *Jit[V]) generateFunction(b *bytes.Buffer, symbol string, fun *parser2.ClosureLiteral, name string, m *MetaData) error {
+	if len(fun.Names) != len(m.Parameters) {
+		return fmt.Errorf("Codegen: %d arguments required, but %d types given", len(fun.Names), len(m.Parameters))
+	}
+	s := jitScope{}
+	var self *jitSelf
+	if name != "" {
+		self = &jitSelf{name: name, entry: symbol, body: "jit" + symbol}
+		for _, arg := range m.Parameters {
+			self.params = append(self.params, arg.Type)
+		}
+		s.self = self
+	}
+	for _, arg := range m.Parameters {
+		s = s.with(arg.Name, arg.Type)
+	}
+	code, typ, err := j.codegenBody(fun.Func, s, self)
+	if err != nil {
+		return err
+	}
+
+	if self != nil {
+		fmt.Fprintf(b, "func %s(jitSt any", self.body)
+		for _, arg := range m.Parameters {
+			fmt.Fprintf(b, ", %s %s", jitIdent(arg.Name), arg.Type)
+		}
+		fmt.Fprintf(b, ") %s {\n\treturn %s\n}\n\n", typ, code)
+	}
+
 	b.WriteString("func ")
-	b.WriteString("JIT_")
-	b.WriteString(fun.Name)
-	b.WriteString("(args ...any) (any, error) { ")
+	b.WriteString(symbol)
+	b.WriteString("(jitSt any, args ...any) (res any, err error) {\n")
+	fmt.Fprintf(b, "\tif len(args) != %d {\n\t\treturn nil, JIT_Deopt\n\t}\n", len(m.Parameters))
+	b.WriteString("\tdefer jitRecover(&err)\n")
+	ids := make([]string, len(m.Parameters))
 	for i, arg := range m.Parameters {
-		b.WriteString(arg.Name)
-		b.WriteString(" := ")
-		b.WriteString("args[")
-		if i > 9 {
-			return errors.New("More than 9 arguments not supported for compiled functions")
-		}
-		b.WriteRune(rune(i + 48))
-		b.WriteString("].(")
-		b.WriteString(arg.Type)
-		b.WriteString(");")
-	}
-	b.WriteString("return ")
-	err := j.codegen(b, fun.Func)
+		id := jitIdent(arg.Name)
+		ids[i] = id
+		if arg.Type == jitAny {
+			fmt.Fprintf(b, "\t%s := args[%d]\n", id, i)
+		} else {
+			fmt.Fprintf(b, "\t%s, ok%d := args[%d].(%s)\n\tif !ok%d {\n\t\treturn nil, JIT_Deopt\n\t}\n", id, i, i, arg.Type, i)
+		}
+		fmt.Fprintf(b, "\t_ = %s\n", id)
+	}
+	if self != nil {
+		code = self.body + "(" + strings.Join(append([]string{"jitSt"}, ids...), ", ") + ")"
+	}
+	b.WriteString("\treturn ")
+	b.WriteString(code)
+	b.WriteString(", nil\n}\n")
+	return nil
+}
+
+// codegenBody generates the body of a function. If the function is able to
+// call itself, the result type of the recursive calls is not known in
+// advance. At first, the result type is assumed to be unknown. Afterwards it
+// is checked if the body also has a more specific type if the recursive calls
+// are assumed to return this type. Since the go compiler checks the types,
+// an inconsistent assumption can not lead to wrong results.
+func (j *Jit[V]) codegenBody(body parser2.AST, s jitScope, self *jitSelf) (string, string, error) {
+	if self == nil {
+		return j.codegen(body, s)
+	}
+	self.result = jitAny
+	code, typ, err := j.codegen(body, s)
+	if err != nil || !self.called {
+		return code, typ, err
+	}
+	candidates := []string{jitInt, jitFloat, jitString, jitBoolT}
+	if typ != jitAny {
+		candidates = []string{typ}
+	}
+	for _, c := range candidates {
+		self.result = c
+		cCode, cTyp, err := j.codegen(body, s)
+		if err == nil && cTyp == c {
+			return cCode, cTyp, nil
+		}
+	}
+	self.result = jitAny
+	return code, typ, nil
+}
+
+// jitPrelude contains the runtime helpers used by the generated code.
+// The JIT_ variables are set by the host after the plugin is opened, they
+// are used to call back into the interpreter, e.g. to call static functions
+// or methods. Each generated function receives the stack of the calling
+// evaluation as jitSt and passes it back to the host, so that the callbacks
+// share the state and the limits of this evaluation. The generated code only
+// depends on the standard library because the plugin is compiled outside the
+// module.
+const jitPrelude = `package main
+
+import "fmt"
+
+var JIT_CallStatic func(st any, name string, args ...any) (any, error)
+var JIT_CallMethod func(st any, value any, name string, args ...any) (any, error)
+var JIT_CallFunc func(st any, f any, args ...any) (any, error)
+var JIT_Closure func(args int, f func(any, ...any) (any, error)) any
+var JIT_Operate func(st any, op string, a, b any) (any, error)
+var JIT_Unary func(op string, a any) (any, error)
+var JIT_Equal func(st any, a, b any) (bool, error)
+var JIT_Deopt error
+
+func jitRecover(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok {
+			*err = fmt.Errorf("jit: %w", e)
+		} else {
+			*err = fmt.Errorf("jit: %v", r)
+		}
+	}
+}
+
+func jitCheck(v any, err error) any {
 	if err != nil {
-		return err
+		panic(err)
 	}
-	b.WriteString(", nil}")
-	return err
+	return v
 }
 
-func (j *Jit[V]) codeGenWithoutAstTypes(b *bytes.Buffer, a any) error {
-	switch t := a.(type) {
+func jitBool(v any) bool {
+	return v.(bool)
+}
+
+func jitEqual(st any, a, b any) bool {
+	eq, err := JIT_Equal(st, a, b)
+	if err != nil {
+		panic(err)
+	}
+	return eq
+}
+
+func jitCall(st any, f any, args ...any) any {
+	if fu, ok := f.(func(any, ...any) (any, error)); ok {
+		return jitCheck(fu(st, args...))
+	}
+	return jitCheck(JIT_CallFunc(st, f, args...))
+}
+
+func jitMapAccess(m any, key string) any {
+	v, ok := m.(map[string]any)[key]
+	if !ok {
+		panic("key not found: " + key)
+	}
+	return v
+}
+
+func jitListAccess(l any, index any) any {
+	switch i := index.(type) {
+	case int:
+		return l.([]any)[i]
+	case float64:
+		return l.([]any)[int(i)]
+	}
+	panic("index is not an int")
+}
+`
+
+// jitScope maps the identifiers visible in the generated code to their go
+// types. If self is set, the compiled function can call itself.
+type jitScope struct {
+	vars map[string]string
+	self *jitSelf
+}
+
+func (s jitScope) with(name, typ string) jitScope {
+	n := make(map[string]string, len(s.vars)+1)
+	for k, v := range s.vars {
+		n[k] = v
+	}
+	n[name] = typ
+	return jitScope{vars: n, self: s.self}
+}
+
+// isSelf returns true if the identifier refers to the compiled function itself
+func (s jitScope) isSelf(name string) bool {
+	if s.self == nil || s.self.name != name {
+		return false
+	}
+	_, shadowed := s.vars[name]
+	return !shadowed
+}
+
+// jitSelf describes a named function which is able to call itself
+type jitSelf struct {
+	name string
+	// entry is the symbol of the function called by the host
+	entry string
+	// body is the name of the typed go function containing the body
+	body string
+	// params are the types of the arguments
+	params []string
+	// result is the assumed result type of the body
+	result string
+	// called is set if the body calls the function
+	called bool
+}
+
+// jitSymbol returns the symbol of a compiled function. It only depends on
+// the name of the function or, if it has none, on the line the closure
+// is defined in, so the generated source is stable across runs.
+func jitSymbol(name string, line parser2.Line) string {
+	if name == "" {
+		return "JIT_closure_L" + strconv.Itoa(int(line))
+	}
+	return "JIT_" + jitMangle(name)
+}
+
+// jitIdent returns the go identifier used for the given script identifier.
+// The prefix avoids collisions with go keywords and the runtime helpers.
+// Script identifiers like 'my var' can contain characters not allowed in go
+// identifiers. These are escaped as _u<hex>_, and the underscore itself is
+// escaped as __, so distinct script identifiers never map to the same go
+// identifier.
+func jitIdent(name string) string {
+	return "v_" + jitMangle(name)
+}
+
+// jitMangle escapes all characters not allowed in go identifiers
+func jitMangle(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '_':
+			b.WriteString("__")
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, "_u%x_", r)
+		}
+	}
+	return b.String()
+}
+
+const (
+	jitInt    = "int"
+	jitFloat  = "float64"
+	jitString = "string"
+	jitBoolT  = "bool"
+	jitAny    = "any"
+)
+
+// codegen returns the go expression of the given ast and its go type
+func (j *Jit[V]) codegen(ast parser2.AST, s jitScope) (string, string, error) {
+	switch t := ast.(type) {
 	case *parser2.Const[V]:
-		err := j.constantWriter(b, j.ValueToUnderlying(t.Value))
-		if err != nil {
-			return err
+		return j.constant(j.ValueToUnderlying(t.Value))
+	case *parser2.Ident:
+		if s.isSelf(t.Name) {
+			return "", "", fmt.Errorf("Codegen: function %s used as value not supported by jit", t.Name)
+		}
+		typ, ok := s.vars[t.Name]
+		if !ok {
+			return "", "", fmt.Errorf("Codegen: identifier %s not available in compiled function", t.Name)
 		}
+		return jitIdent(t.Name), typ, nil
 	case *parser2.Let:
-		b.WriteString(t.Name)
-		b.WriteString(":=")
-		err := j.codeGenWithoutAstTypes(b, t.Value)
+		vs := s
+		if _, ok := t.Value.(*parser2.ClosureLiteral); ok {
+			// allows recursive closures
+			vs = s.with(t.Name, jitAny)
+		}
+		value, vt, err := j.codegen(t.Value, vs)
 		if err != nil {
-			return err
+			return "", "", err
+		}
+		inner, it, err := j.codegen(t.Inner, s.with(t.Name, vt))
+		if err != nil {
+			return "", "", err
+		}
+		id := jitIdent(t.Name)
+		return fmt.Sprintf("func() %s { var %s %s; %s = %s; _ = %s; return %s }()", it, id, vt, id, value, id, inner), it, nil
+	case *parser2.If:
+		cond, ct, err := j.codegen(t.Cond, s)
+		if err != nil {
+			return "", "", err
+		}
+		then, tt, err := j.codegen(t.Then, s)
+		if err != nil {
+			return "", "", err
 		}
+		els, et, err := j.codegen(t.Else, s)
+		if err != nil {
+			return "", "", err
+		}
+		typ := jitAny
+		if tt == et {
+			typ = tt
+		}
+		return fmt.Sprintf("func() %s { if %s { return %s }; return %s }()", typ, asBool(cond, ct), then, els), typ, nil
+	case *parser2.Switch[V]:
+		value, vt, err := j.codegen(t.SwitchValue, s)
+		if err != nil {
+			return "", "", err
+		}
+		def, typ, err := j.codegen(t.Default, s)
+		if err != nil {
+			return "", "", err
+		}
+		var b strings.Builder
+		for _, c := range t.Cases {
+			cc, cct, err := j.codegen(c.CaseConst, s)
+			if err != nil {
+				return "", "", err
+			}
+			r, rt, err := j.codegen(c.Value, s)
+			if err != nil {
+				return "", "", err
+			}
+			if rt != typ {
+				typ = jitAny
+			}
+			if cct == vt && isPrimitive(vt) {
+				fmt.Fprintf(&b, "if jitSwitch == %s { return %s }; ", cc, r)
+			} else {
+				fmt.Fprintf(&b, "if jitEqual(jitSt, jitSwitch, %s) { return %s }; ", cc, r)
+			}
+		}
+		return fmt.Sprintf("func() %s { jitSwitch := %s; _ = jitSwitch; %sreturn %s }()", typ, value, b.String(), def), typ, nil
+	case *parser2.Operate:
+		return j.operate(t, s)
+	case *parser2.Unary:
+		value, vt, err := j.codegen(t.Value, s)
+		if err != nil {
+			return "", "", err
+		}
+		switch {
+		case t.Operator == "-" && isNumber(vt):
+			return "(-" + value + ")", vt, nil
+		case t.Operator == "!" && vt == jitBoolT:
+			return "(!" + value + ")", jitBoolT, nil
+		}
+		return fmt.Sprintf("jitCheck(JIT_Unary(%s, %s))", strconv.Quote(t.Operator), value), jitAny, nil
+	case *parser2.ListLiteral:
+		items, err := j.codegenList(t.List, s)
+		if err != nil {
+			return "", "", err
+		}
+		return "[]any{" + items + "}", "[]any", nil
+	case *parser2.ListAccess:
+		list, _, err := j.codegen(t.List, s)
+		if err != nil {
+			return "", "", err
+		}
+		index, _, err := j.codegen(t.Index, s)
+		if err != nil {
+			return "", "", err
+		}
+		return fmt.Sprintf("jitListAccess(%s, %s)", list, index), jitAny, nil
 	case *parser2.MapLiteral:
-		err := j.constantWriter(b, t.Map.ToNative())
+		var b strings.Builder
+		b.WriteString("map[string]any{")
+		var err error
+		t.Map.Iter(func(key string, value parser2.AST) bool {
+			var v string
+			v, _, err = j.codegen(value, s)
+			if err != nil {
+				return false
+			}
+			fmt.Fprintf(&b, "%s: %s, ", strconv.Quote(key), v)
+			return true
+		})
 		if err != nil {
-			return err
+			return "", "", err
 		}
-	case *parser2.Ident:
-		b.WriteString(t.Name)
+		b.WriteString("}")
+		return b.String(), "map[string]any", nil
 	case *parser2.MapAccess:
-		j.codegen(b, t.MapValue)
-		b.WriteString("[\"")
-		b.WriteString(t.Key)
-		b.WriteString("\"]")
-	case *parser2.Operate:
-		err := j.codeGenWithoutAstTypes(b, t.A)
+		m, _, err := j.codegen(t.MapValue, s)
 		if err != nil {
-			return err
+			return "", "", err
 		}
-		op := t.Operator
-		switch op {
-		case "=":
-			op = "=="
-		case "&":
-			op = "&&"
-		case "|":
-			op = "||"
-		}
-		b.WriteString(op)
-		err = j.codeGenWithoutAstTypes(b, t.B)
+		return fmt.Sprintf("jitMapAccess(%s, %s)", m, strconv.Quote(t.Key)), jitAny, nil
+	case *parser2.FunctionCall:
+		args, err := j.codegenList(t.Args, s)
 		if err != nil {
-			return err
+			return "", "", err
 		}
-	case *parser2.Unary:
-		b.WriteString(t.Operator)
-		err := j.codeGenWithoutAstTypes(b, t.Value)
+		if id, ok := t.Func.(*parser2.Ident); ok {
+			if _, ok := j.g.staticFunctions[id.Name]; ok {
+				return fmt.Sprintf("jitCheck(JIT_CallStatic(jitSt, %s, %s))", strconv.Quote(id.Name), args), jitAny, nil
+			}
+		}
+		if id, ok := t.Func.(*parser2.Ident); ok && s.isSelf(id.Name) {
+			return j.callSelf(t.Args, s)
+		}
+		f, _, err := j.codegen(t.Func, s)
 		if err != nil {
-			return err
+			return "", "", err
+		}
+		return fmt.Sprintf("jitCall(jitSt, %s, %s)", f, args), jitAny, nil
+	case *parser2.MethodCall:
+		value, _, err := j.codegen(t.Value, s)
+		if err != nil {
+			return "", "", err
+		}
+		args, err := j.codegenList(t.Args, s)
+		if err != nil {
+			return "", "", err
+		}
+		return fmt.Sprintf("jitCheck(JIT_CallMethod(jitSt, %s, %s, %s))", value, strconv.Quote(t.Name), args), jitAny, nil
+	case *parser2.ClosureLiteral:
+		if t.Memo {
+			return "", "", errors.New("Codegen: memoized closures are not supported by jit")
 		}
-	default:
-		return fmt.Errorf("Codegen: Expression %T not yet supported by jit", t)
+		var b strings.Builder
+		fmt.Fprintf(&b, "JIT_Closure(%d, func(jitSt any, args ...any) (res any, err error) { defer jitRecover(&err); ", len(t.Names))
+		inner := s
+		for i, n := range t.Names {
+			inner = inner.with(n, jitAny)
+			fmt.Fprintf(&b, "%s := args[%d]; _ = %s; ", jitIdent(n), i, jitIdent(n))
+		}
+		body, _, err := j.codegen(t.Func, inner)
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Fprintf(&b, "return %s, nil })", body)
+		return b.String(), jitAny, nil
 	}
-	return nil
+	return "", "", fmt.Errorf("Codegen: Expression %T not yet supported by jit", ast)
+}
+
+// callSelf creates a recursive call. If the types of the arguments match the
+// types the function is compiled for, the typed body is called directly.
+// Otherwise, the entry is called, which checks the types of the arguments.
+func (j *Jit[V]) callSelf(args []parser2.AST, s jitScope) (string, string, error) {
+	self := s.self
+	if len(args) != len(self.params) {
+		return "", "", fmt.Errorf("Codegen: function %s requires %d arguments", self.name, len(self.params))
+	}
+	self.called = true
+	items := make([]string, len(args)+1)
+	items[0] = "jitSt"
+	direct := true
+	for i, a := range args {
+		code, typ, err := j.codegen(a, s)
+		if err != nil {
+			return "", "", err
+		}
+		if typ != self.params[i] && self.params[i] != jitAny {
+			direct = false
+		}
+		items[i+1] = code
+	}
+	if direct {
+		return self.body + "(" + strings.Join(items, ", ") + ")", self.result, nil
+	}
+	return "jitCheck(" + self.entry + "(" + strings.Join(items, ", ") + "))", jitAny, nil
+}
+
+func (j *Jit[V]) codegenList(list []parser2.AST, s jitScope) (string, error) {
+	items := make([]string, len(list))
+	for i, a := range list {
+		code, _, err := j.codegen(a, s)
+		if err != nil {
+			return "", err
+		}
+		items[i] = code
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// operate creates native go operations if the types of both operands are
+// known, otherwise the operation of the interpreter is called.
+func (j *Jit[V]) operate(o *parser2.Operate, s jitScope) (string, string, error) {
+	a, at, err := j.codegen(o.A, s)
+	if err != nil {
+		return "", "", err
+	}
+	b, bt, err := j.codegen(o.B, s)
+	if err != nil {
+		return "", "", err
+	}
+	native := func(op, typ string) (string, string, error) {
+		return "(" + a + " " + op + " " + b + ")", typ, nil
+	}
+	// mixed int and float operands are converted to float
+	toFloat := func(op, typ string) (string, string, error) {
+		return "(" + asFloat(a, at) + " " + op + " " + asFloat(b, bt) + ")", typ, nil
+	}
+	op := o.Operator
+	switch op {
+	case "+", "-", "*":
+		if at == jitInt && bt == jitInt {
+			return native(op, jitInt)
+		}
+		if isNumber(at) && isNumber(bt) {
+			return toFloat(op, jitFloat)
+		}
+		if op == "+" && at == jitString && bt == jitString {
+			return native(op, jitString)
+		}
+	case "/":
+		// the division of two ints is a float
+		if isNumber(at) && isNumber(bt) {
+			return toFloat(op, jitFloat)
+		}
+	case "%":
+		if at == jitInt && bt == jitInt {
+			return native(op, jitInt)
+		}
+	case "<", ">", "<=", ">=":
+		if at == bt && (isNumber(at) || at == jitString) {
+			return native(op, jitBoolT)
+		}
+		if isNumber(at) && isNumber(bt) {
+			return toFloat(op, jitBoolT)
+		}
+	case "=", "!=":
+		goOp := op
+		if op == "=" {
+			goOp = "=="
+		}
+		if at == bt && isPrimitive(at) {
+			return native(goOp, jitBoolT)
+		}
+		if isNumber(at) && isNumber(bt) {
+			return toFloat(goOp, jitBoolT)
+		}
+	case "&", "|":
+		// go evaluates the second operand only if required, as the interpreter does
+		goOp := "&&"
+		if op == "|" {
+			goOp = "||"
+		}
+		return "(" + asBool(a, at) + " " + goOp + " " + asBool(b, bt) + ")", jitBoolT, nil
+	}
+	return fmt.Sprintf("jitCheck(JIT_Operate(jitSt, %s, %s, %s))", strconv.Quote(op), a, b), jitAny, nil
+}
+
+func asBool(code, typ string) string {
+	if typ == jitBoolT {
+		return code
+	}
+	return "jitBool(" + code + ")"
+}
+
+func asFloat(code, typ string) string {
+	if typ == jitInt {
+		return "float64(" + code + ")"
+	}
+	return code
 }
 
-func (j *Jit[V]) constantWriter(b *bytes.Buffer, a any) error {
+func isNumber(typ string) bool {
+	return typ == jitInt || typ == jitFloat
+}
+
+func isPrimitive(typ string) bool {
+	return isNumber(typ) || typ == jitString || typ == jitBoolT
+}
+
+// constant returns the go expression of a constant and its go type
+func (j *Jit[V]) constant(a any) (string, string, error) {
 	switch t := a.(type) {
 	case int:
-		b.WriteString(strconv.FormatInt(int64(t), 10))
+		return "int(" + strconv.Itoa(t) + ")", jitInt, nil
 	case float64:
-		b.WriteString(strconv.FormatFloat(t, 'E', -1, 64))
+		// go constants can not represent these values
+		if math.IsInf(t, 0) || math.IsNaN(t) || (t == 0 && math.Signbit(t)) {
+			return "", "", fmt.Errorf("Codegen: constant %v not supported by jit", t)
+		}
+		return "float64(" + strconv.FormatFloat(t, 'g', -1, 64) + ")", jitFloat, nil
 	case string:
-		b.WriteRune('"')
-		b.WriteString(t)
-		b.WriteRune('"')
-
-		// TODO: test this
-		// case []any:
-		// 	b.WriteString("[]any{")
-		// 	for _, v := range t {
-		// 		err := j.writer(b, v)
-		// 		if err != nil {
-		// 			return err
-		// 		}
-		// 		b.WriteRune(',')
-		// 	}
-		// 	b.WriteRune('}')
+		return strconv.Quote(t), jitString, nil
+	case bool:
+		return strconv.FormatBool(t), jitBoolT, nil
+	case []any:
+		items := make([]string, len(t))
+		for i, v := range t {
+			code, _, err := j.constant(v)
+			if err != nil {
+				return "", "", err
+			}
+			items[i] = code
+		}
+		return "[]any{" + strings.Join(items, ", ") + "}", "[]any", nil
 	case map[string]any:
-		b.WriteString("map[string]any{")
-		for k, v := range t {
-			b.WriteRune('"')
-			b.WriteString(k)
-			b.WriteString("\":")
-			err := j.codeGenWithoutAstTypes(b, v)
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			code, _, err := j.constant(t[k])
 			if err != nil {
-				return err
+				return "", "", err
 			}
-			b.WriteRune(',')
+			items[i] = strconv.Quote(k) + ": " + code
 		}
-		b.WriteRune('}')
-	default:
-		return fmt.Errorf("Codegen: Expression %T not yet supported by constantWriter jit component", t)
+		return "map[string]any{" + strings.Join(items, ", ") + "}", "map[string]any", nil
 	}
-	return nil
-}
-
-func (j *Jit[V]) codegen(b *bytes.Buffer, ast parser2.AST) error {
-	return j.codeGenWithoutAstTypes(b, ast)
+	return "", "", fmt.Errorf("Codegen: constant of type %T not supported by jit", a)
 }
 
 // invokeCompiler invokes the go compiler to create a shared object / go plugin from
 // the go file at the specifed path
 func invokeCompiler(path string) (soPath string, err error) {
-	soPath = strings.Replace(path, ".go", ".so", 1)
-	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", soPath, path)
-	// TODO: return stderr and stdout in form of an error
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err = cmd.Run()
-	return
+	soPath = strings.TrimSuffix(path, ".go") + ".so"
+	args := []string{"build", "-buildmode=plugin"}
+	if raceEnabled() {
+		// a plugin can only be opened if it is built like the host
+		args = append(args, "-race")
+	}
+	out, err := exec.Command("go", append(args, "-o", soPath, path)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("go build failed: %w\n%s", err, bytes.TrimSpace(out))
+	}
+	return soPath, nil
 }
 
-// function extracts and returns the function with the given name from the
-// shared object / go plugin at the given path
-func (j *Jit[V]) function(name string, path string) (func(...any) (V, error), error) {
+// open opens the shared object / go plugin at the given path and links
+// it to the runtime
+func (j *Jit[V]) open(path string) (*plugin.Plugin, error) {
 	p, err := plugin.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	err = j.link(p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// raceEnabled returns true if the host is built with the race detector
+func raceEnabled() bool {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "-race" {
+				return s.Value == "true"
+			}
+		}
+	}
+	return false
+}
+
+// function extracts and returns the function with the given name from the
+// given go plugin
+func (j *Jit[V]) function(p *plugin.Plugin, name string) (func(Stack[V], ...any) (V, error), error) {
 	symbol, err := p.Lookup(name)
 	if err != nil {
 		return nil, err
 	}
 
-	funct, ok := symbol.(func(...any) (any, error))
+	funct, ok := symbol.(func(any, ...any) (any, error))
 	if !ok {
-		var e func(...any) (any, error)
+		var e func(any, ...any) (any, error)
 		return nil, fmt.Errorf("Failed to cast symbol of type %T to %T", symbol, e)
 	}
-	return func(a ...any) (V, error) {
-		out, err := funct(a...)
+	return func(st Stack[V], a ...any) (V, error) {
+		out, err := funct(st, a...)
 		if err != nil {
 			var e V
 			return e, err
 		}
-		return j.UnderlyingToValue(out), nil
+		return j.toValue(out), nil
 	}, nil
 }
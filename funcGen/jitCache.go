@@ -0,0 +1,197 @@
+package funcGen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const modulePath = "github.com/hneemann/parser2"
+
+// hostVersion identifies the version of this module the host is built with.
+// If the module is a versioned dependency, its version and checksum are used.
+// Otherwise, e.g. during development, the hash of the executable is used, so
+// the cache is invalidated every time the host is rebuilt.
+var hostVersion = sync.OnceValue(func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, d := range info.Deps {
+			if d.Path == modulePath && d.Replace == nil && d.Sum != "" {
+				return d.Version + " " + d.Sum
+			}
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+})
+
+// cacheKey returns the key a plugin is stored with in the cache.
+func (j *Jit[V]) cacheKey(src []byte, meta []*MetaData) string {
+	h := sha256.New()
+	h.Write(src)
+	fmt.Fprintf(h, "\x00%s\x00%s/%s\x00%s\x00%s", runtime.Version(), runtime.GOOS, runtime.GOARCH, hostVersion(), j.CacheVersion)
+	for _, m := range meta {
+		h.Write([]byte{1})
+		for _, p := range m.Parameters {
+			fmt.Fprintf(h, "\x00%s", p.Type)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// build returns the path of the plugin created from the given source.
+// The returned function needs to be called after the plugin is opened.
+// If a cache directory is set, the plugin is taken from the cache if
+// available, otherwise the newly built plugin is stored in the cache.
+func (j *Jit[V]) build(src []byte, meta []*MetaData) (string, func(), error) {
+	if j.CacheDir == "" {
+		path, err := compileSource(os.TempDir(), src)
+		if err != nil {
+			return "", nil, err
+		}
+		return path, func() { os.Remove(path) }, nil
+	}
+
+	err := os.MkdirAll(j.CacheDir, 0o755)
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(j.CacheDir, j.cacheKey(src, meta)+".so")
+	if _, err := os.Stat(path); err == nil {
+		// the modification time is used to evict the least recently used plugins
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		j.event(JitEvent{Kind: JitCacheHit, Path: path})
+		return path, func() {}, nil
+	}
+
+	soPath, err := compileSource(j.CacheDir, src)
+	if err != nil {
+		return "", nil, err
+	}
+	// rename is atomic, so concurrent processes never see a partial plugin
+	err = os.Rename(soPath, path)
+	if err != nil {
+		os.Remove(soPath)
+		return "", nil, err
+	}
+	j.evictCache(path)
+	return path, func() {}, nil
+}
+
+// compileSource writes the source to a temporary file in the given
+// directory and invokes the compiler
+func compileSource(dir string, src []byte) (string, error) {
+	f, err := os.CreateTemp(dir, "JIT_*.go")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return invokeCompiler(f.Name())
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (j *Jit[V]) cacheEntries() ([]cacheEntry, error) {
+	files, err := os.ReadDir(j.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".so") || strings.HasPrefix(f.Name(), "JIT_") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cacheEntry{
+			path:    filepath.Join(j.CacheDir, f.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+// evictCache removes the least recently used plugins until the size of
+// the cache is below CacheMaxSize. The plugin at keep is never removed.
+func (j *Jit[V]) evictCache(keep string) {
+	if j.CacheMaxSize <= 0 {
+		return
+	}
+	entries, err := j.cacheEntries()
+	if err != nil {
+		return
+	}
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].modTime.Before(entries[b].modTime)
+	})
+	for _, e := range entries {
+		if size <= j.CacheMaxSize {
+			return
+		}
+		if e.path == keep {
+			continue
+		}
+		if err := os.Remove(e.path); err == nil {
+			size -= e.size
+		}
+	}
+}
+
+// ClearCache removes all plugins from the cache directory.
+func (j *Jit[V]) ClearCache() error {
+	if j.CacheDir == "" {
+		return nil
+	}
+	entries, err := j.cacheEntries()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Remove(e.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
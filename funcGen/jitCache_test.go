@@ -0,0 +1,61 @@
+package funcGen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createCacheEntry(t *testing.T, dir, name string, size int, age time.Duration) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+	mod := time.Now().Add(-age)
+	assert.NoError(t, os.Chtimes(path, mod, mod))
+	return path
+}
+
+func TestEvictCache(t *testing.T) {
+	dir := t.TempDir()
+	oldest := createCacheEntry(t, dir, "a.so", 100, 3*time.Hour)
+	old := createCacheEntry(t, dir, "b.so", 100, 2*time.Hour)
+	young := createCacheEntry(t, dir, "c.so", 100, time.Hour)
+	kept := createCacheEntry(t, dir, "d.so", 100, 4*time.Hour)
+	other := createCacheEntry(t, dir, "readme.txt", 1000, 5*time.Hour)
+
+	j := &Jit[int]{CacheDir: dir, CacheMaxSize: 250}
+	j.evictCache(kept)
+
+	assert.NoFileExists(t, oldest)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, young)
+	assert.FileExists(t, kept)
+	assert.FileExists(t, other)
+}
+
+func TestCacheKey(t *testing.T) {
+	j := &Jit[int]{}
+	floatArg := []*MetaData{{Parameters: []MetaDataParameter{{Name: "a", Type: "float64"}}}}
+	stringArg := []*MetaData{{Parameters: []MetaDataParameter{{Name: "a", Type: "string"}}}}
+	key := j.cacheKey([]byte("src"), floatArg)
+	assert.Equal(t, key, j.cacheKey([]byte("src"), floatArg))
+	assert.NotEqual(t, key, j.cacheKey([]byte("src2"), floatArg))
+	assert.NotEqual(t, key, j.cacheKey([]byte("src"), stringArg))
+	j.CacheVersion = "2"
+	assert.NotEqual(t, key, j.cacheKey([]byte("src"), floatArg))
+}
+
+func TestClearCache(t *testing.T) {
+	dir := t.TempDir()
+	plugin := createCacheEntry(t, dir, "a.so", 100, 0)
+	other := createCacheEntry(t, dir, "readme.txt", 100, 0)
+	j := &Jit[int]{CacheDir: dir}
+	assert.NoError(t, j.ClearCache())
+	assert.NoFileExists(t, plugin)
+	assert.FileExists(t, other)
+
+	j.CacheDir = filepath.Join(dir, "missing")
+	assert.NoError(t, j.ClearCache())
+}
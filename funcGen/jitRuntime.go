@@ -0,0 +1,193 @@
+package funcGen
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+)
+
+// errDeopt is returned by a compiled function if the types of the arguments
+// do not match the types the function was compiled for
+var errDeopt = errors.New("jit: argument types do not match")
+
+// enqueue passes the function to the compiler. In synchronous mode the
+// function is compiled immediately.
+func (j *Jit[V]) enqueue(f *Function[V]) {
+	j.stats.queued.Add(1)
+	j.event(JitEvent{Kind: JitQueued, Name: f.jitName(), Signature: signature(f.MetaData)})
+	if j.Synchronous {
+		// failures are reported by events, the function is interpreted
+		j.Compile(f)
+		return
+	}
+	j.Queue <- f
+}
+
+// toValue converts a value returned by a compiled function. Values the
+// compiled code can not handle itself, like closures, are passed through
+// the compiled code unchanged.
+func (j *Jit[V]) toValue(a any) V {
+	if v, ok := a.(V); ok {
+		return v
+	}
+	return j.UnderlyingToValue(a)
+}
+
+// stack returns a new stack which shares the evaluation state and the limits
+// with the stack st passed to the compiled code by the host.
+func (j *Jit[V]) stack(st any) Stack[V] {
+	return st.(Stack[V]).New()
+}
+
+func (j *Jit[V]) toStack(st any, args []any) Stack[V] {
+	s := j.stack(st)
+	for _, a := range args {
+		s.Push(j.toValue(a))
+	}
+	return s
+}
+
+func (j *Jit[V]) result(v V, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return j.ValueToUnderlying(v), nil
+}
+
+// link sets the runtime variables of the plugin
+func (j *Jit[V]) link(p *plugin.Plugin) error {
+	var err error
+	set := func(name string, f any) {
+		if err != nil {
+			return
+		}
+		var symbol plugin.Symbol
+		symbol, err = p.Lookup(name)
+		if err != nil {
+			return
+		}
+		switch s := symbol.(type) {
+		case *func(any, string, ...any) (any, error):
+			*s = f.(func(any, string, ...any) (any, error))
+		case *func(any, any, string, ...any) (any, error):
+			*s = f.(func(any, any, string, ...any) (any, error))
+		case *func(any, any, ...any) (any, error):
+			*s = f.(func(any, any, ...any) (any, error))
+		case *func(int, func(any, ...any) (any, error)) any:
+			*s = f.(func(int, func(any, ...any) (any, error)) any)
+		case *func(any, string, any, any) (any, error):
+			*s = f.(func(any, string, any, any) (any, error))
+		case *func(string, any) (any, error):
+			*s = f.(func(string, any) (any, error))
+		case *func(any, any, any) (bool, error):
+			*s = f.(func(any, any, any) (bool, error))
+		case *error:
+			*s = f.(error)
+		default:
+			err = fmt.Errorf("unexpected runtime symbol %s of type %T", name, symbol)
+		}
+	}
+	set("JIT_CallStatic", j.callStatic)
+	set("JIT_CallMethod", j.callMethod)
+	set("JIT_CallFunc", j.callFunc)
+	set("JIT_Closure", j.closure)
+	set("JIT_Operate", j.callOperate)
+	set("JIT_Unary", j.callUnary)
+	set("JIT_Equal", j.equal)
+	set("JIT_Deopt", errDeopt)
+	return err
+}
+
+func (j *Jit[V]) callStatic(st any, name string, args ...any) (any, error) {
+	f, ok := j.g.staticFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("static function %s not found", name)
+	}
+	if f.argsNumberNotMatching(len(args)) {
+		return nil, errors.New(f.argsNumberNotMatchingError(name, len(args)))
+	}
+	return j.result(f.Func(j.toStack(st, args), nil))
+}
+
+func (j *Jit[V]) callFunc(st any, fu any, args ...any) (any, error) {
+	f, ok := j.g.ExtractFunction(j.toValue(fu))
+	if !ok {
+		return nil, errors.New("not a function")
+	}
+	if f.argsNumberNotMatching(len(args)) {
+		return nil, errors.New(f.argsNumberNotMatchingError("function", len(args)))
+	}
+	return j.result(f.Func(j.toStack(st, args), nil))
+}
+
+func (j *Jit[V]) callMethod(st any, v any, name string, args ...any) (any, error) {
+	g := j.g
+	value := j.toValue(v)
+	// name could be a method, but it could also be the name of a field which stores a closure
+	if g.mapHandler != nil && g.mapHandler.IsMap(value) {
+		if va, err := g.mapHandler.AccessMap(value, name); err == nil {
+			if f, ok := g.ExtractFunction(va); ok {
+				if f.argsNumberNotMatching(len(args)) {
+					return nil, errors.New(f.argsNumberNotMatchingError(name, len(args)))
+				}
+				return j.result(f.Func(j.toStack(st, args), nil))
+			}
+		}
+	}
+	if g.methodHandler == nil {
+		return nil, fmt.Errorf("method %s not found", name)
+	}
+	me, err := g.methodHandler.GetMethod(value, name)
+	if err != nil {
+		return nil, err
+	}
+	if me.Args > 0 && me.Args != len(args)+1 {
+		return nil, fmt.Errorf("wrong number of arguments at call of \"%s\", required %d, found %d", me.Description.String(name), me.Args-1, len(args))
+	}
+	ms := j.stack(st)
+	ms.Push(value)
+	for _, a := range args {
+		ms.Push(j.toValue(a))
+	}
+	return j.result(me.Func(ms, nil))
+}
+
+// closure creates a function value from a closure literal compiled into the
+// plugin. The closure is called with the stack of the calling evaluation.
+func (j *Jit[V]) closure(n int, f func(any, ...any) (any, error)) any {
+	return j.g.closureHandler.FromClosure(Function[V]{
+		Func: func(st Stack[V], _ []V) (V, error) {
+			args := make([]any, st.Size())
+			for i := range args {
+				args[i] = j.ValueToUnderlying(st.Get(i))
+			}
+			r, err := f(st, args...)
+			if err != nil {
+				var zero V
+				return zero, err
+			}
+			return j.toValue(r), nil
+		},
+		Args: n,
+	})
+}
+
+func (j *Jit[V]) callOperate(st any, name string, a, b any) (any, error) {
+	o, ok := j.g.opMap[name]
+	if !ok {
+		return nil, fmt.Errorf("operation %s not found", name)
+	}
+	return j.result(o.Impl(j.stack(st), j.toValue(a), j.toValue(b)))
+}
+
+func (j *Jit[V]) callUnary(name string, a any) (any, error) {
+	u, ok := j.g.uMap[name]
+	if !ok {
+		return nil, fmt.Errorf("unary operation %s not found", name)
+	}
+	return j.result(u.Impl(j.toValue(a)))
+}
+
+func (j *Jit[V]) equal(st any, a, b any) (bool, error) {
+	return j.g.isEqual(j.stack(st), j.toValue(a), j.toValue(b))
+}
@@ -0,0 +1,142 @@
+package funcGen
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// maxJitVariants is the maximum number of compiled variants of a function.
+// Each variant is compiled for different argument types.
+const maxJitVariants = 4
+
+// jitState holds the data used to detect hot functions. It is shared by all
+// copies of a function, so no calls are lost if the function is passed by
+// value, and it is safe for concurrent use.
+type jitState[V any] struct {
+	// threshold is the number of calls after which the function is hot
+	threshold int64
+	// counter counts the interpreted calls until the threshold is reached
+	counter atomic.Int64
+	// calls counts all interpreted calls
+	calls    atomic.Int64
+	variants atomic.Pointer[[]*jitVariant[V]]
+}
+
+// jitVariant is a compiled variant of a function
+type jitVariant[V any] struct {
+	f func(Stack[V], ...any) (V, error)
+	// calls counts the calls executed by the compiled code
+	calls atomic.Int64
+	// deopts counts the calls rejected because of the argument types
+	deopts atomic.Int64
+	// bailOuts counts the calls which failed in the compiled code and
+	// were passed to the interpreter
+	bailOuts atomic.Int64
+	// disabled is set after the first bail out. The interpreter repeats a
+	// failed call, so side effects of the compiled code, like calls of
+	// go functions, happen twice. To avoid this for all later failing
+	// calls, a variant which bailed out is no longer used.
+	disabled atomic.Bool
+}
+
+// newJitState creates a new state. If the jit is not enabled, nil is
+// returned, and calls are not counted.
+func (g *FunctionGenerator[V]) newJitState() *jitState[V] {
+	if g.jit == nil || g.instrumented() {
+		return nil
+	}
+	return &jitState[V]{threshold: int64(g.threshold)}
+}
+
+// run calls the first enabled compiled variant whose type guards accept
+// the arguments. If there is no such variant, or if the compiled code fails,
+// false is returned and the call needs to be interpreted. In the latter
+// case the interpreter creates the proper error message, and the variant
+// is disabled. The stack st is passed to the compiled code, which uses it
+// for the calls back into the interpreter.
+func (s *jitState[V]) run(st Stack[V], args ...any) (V, bool) {
+	var zero V
+	vs := s.variants.Load()
+	if vs == nil {
+		return zero, false
+	}
+	for _, v := range *vs {
+		if v.disabled.Load() {
+			continue
+		}
+		out, err := v.f(st, args...)
+		if err == nil {
+			v.calls.Add(1)
+			return out, true
+		}
+		if !errors.Is(err, errDeopt) {
+			v.bailOuts.Add(1)
+			v.disabled.Store(true)
+			return zero, false
+		}
+		v.deopts.Add(1)
+	}
+	return zero, false
+}
+
+// hasVariants returns true if there are compiled variants
+func (s *jitState[V]) hasVariants() bool {
+	return s.variants.Load() != nil
+}
+
+// call counts an interpreted call of f. If true is returned, the function
+// has just reached the threshold and should be passed to the jit. Since the counter is
+// incremented atomically, only a single caller sees the threshold being
+// reached. Above the threshold the counter is no longer written to avoid
+// contention.
+func (s *jitState[V]) call(f *Function[V]) bool {
+	s.calls.Add(1)
+	if s.counter.Load() <= s.threshold {
+		if s.counter.Add(1) == s.threshold+1 {
+			return f.JitCompiler != nil && f.Ast != nil
+		}
+	}
+	return false
+}
+
+// addVariant adds a compiled variant and returns it. If the maximum number
+// of variants is reached, nil is returned. If further variants are allowed,
+// the counting of interpreted calls starts again, so that calls with other
+// argument types can make the function hot again.
+func (s *jitState[V]) addVariant(f func(Stack[V], ...any) (V, error)) *jitVariant[V] {
+	v := &jitVariant[V]{f: f}
+	for {
+		old := s.variants.Load()
+		var vs []*jitVariant[V]
+		if old != nil {
+			vs = append(vs, *old...)
+		}
+		if len(vs) >= maxJitVariants {
+			return nil
+		}
+		vs = append(vs, v)
+		if s.variants.CompareAndSwap(old, &vs) {
+			if len(vs) < maxJitVariants {
+				s.counter.Store(0)
+			}
+			return v
+		}
+	}
+}
+
+// compiled returns the compiled variants. They are called with a new
+// evaluation state.
+func (s *jitState[V]) compiled() []func(...any) (V, error) {
+	vs := s.variants.Load()
+	if vs == nil {
+		return nil
+	}
+	fs := make([]func(...any) (V, error), len(*vs))
+	for i, v := range *vs {
+		f := v.f
+		fs[i] = func(a ...any) (V, error) {
+			return f(NewEmptyStack[V](), a...)
+		}
+	}
+	return fs
+}
@@ -0,0 +1,232 @@
+package funcGen
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hneemann/parser2"
+)
+
+// JitEventKind is the kind of event reported by the jit
+type JitEventKind int
+
+const (
+	// JitQueued is reported if a hot function is passed to the jit
+	JitQueued JitEventKind = iota
+	// JitSkipped is reported if no code could be generated for a function
+	JitSkipped
+	// JitSource is reported if the source of a plugin is generated
+	JitSource
+	// JitCacheHit is reported if a plugin is taken from the cache
+	JitCacheHit
+	// JitCompiled is reported if a function is compiled successfully
+	JitCompiled
+	// JitFailed is reported if a function could not be compiled
+	JitFailed
+)
+
+func (k JitEventKind) String() string {
+	switch k {
+	case JitQueued:
+		return "queued"
+	case JitSkipped:
+		return "skipped"
+	case JitSource:
+		return "source"
+	case JitCacheHit:
+		return "cache hit"
+	case JitCompiled:
+		return "compiled"
+	case JitFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// JitEvent is passed to the event handler of the jit
+type JitEvent struct {
+	Kind JitEventKind
+	// Name is the name of the function, empty if the event concerns a
+	// whole plugin
+	Name string
+	// Signature contains the argument types the function is compiled for
+	Signature string
+	// Source is the generated go source, only set for JitSource
+	Source string
+	// Path is the path of the plugin, only set for JitCacheHit
+	Path string
+	// Duration is the time the compilation took, only set for JitCompiled
+	Duration time.Duration
+	// Err is the reason of the failure, only set for JitSkipped and JitFailed
+	Err error
+}
+
+func (e JitEvent) String() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.String())
+	if e.Name != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Name)
+		b.WriteString("(")
+		b.WriteString(e.Signature)
+		b.WriteString(")")
+	}
+	if e.Path != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Path)
+	}
+	if e.Duration != 0 {
+		b.WriteString(" in ")
+		b.WriteString(e.Duration.String())
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	if e.Source != "" {
+		b.WriteString(":\n")
+		b.WriteString(e.Source)
+	}
+	return b.String()
+}
+
+// JitStats is a snapshot of the statistics of the jit
+type JitStats struct {
+	// Queued is the number of functions passed to the jit
+	Queued int64
+	// Compiled is the number of functions compiled successfully
+	Compiled int64
+	// Failed is the number of functions which could not be compiled
+	Failed int64
+	// BailedOut is the number of compiled functions which failed at
+	// runtime at least once, so the call was passed to the interpreter
+	BailedOut int64
+	// CompileTime is the total time spent compiling
+	CompileTime time.Duration
+	// Functions contains the statistics of the compiled functions
+	Functions []JitFunctionStats
+}
+
+// JitFunctionStats contains the statistics of a compiled function
+type JitFunctionStats struct {
+	Name string
+	// Signature contains the argument types the function is compiled for
+	Signature string
+	// CompileTime is the time the compilation of the plugin containing
+	// this function took
+	CompileTime time.Duration
+	// CallsBefore is the number of interpreted calls before the
+	// function was compiled
+	CallsBefore int64
+	// CallsAfter is the number of calls executed by the compiled code
+	CallsAfter int64
+	// Deopts is the number of calls rejected by the compiled code because
+	// of the argument types
+	Deopts int64
+	// BailOuts is the number of calls which failed in the compiled code
+	// and were passed to the interpreter. After the first bail out the
+	// compiled function is no longer used.
+	BailOuts int64
+}
+
+// jitStats collects the statistics of the jit
+type jitStats[V any] struct {
+	queued      atomic.Int64
+	failed      atomic.Int64
+	compileTime atomic.Int64
+	mutex       sync.Mutex
+	functions   []jitFunctionRecord[V]
+}
+
+type jitFunctionRecord[V any] struct {
+	name        string
+	signature   string
+	compileTime time.Duration
+	callsBefore int64
+	variant     *jitVariant[V]
+}
+
+// event passes the event to the event handler, if there is one
+func (j *Jit[V]) event(e JitEvent) {
+	if j.OnEvent != nil {
+		j.OnEvent(e)
+	}
+}
+
+// failed records and reports a function which could not be compiled
+func (j *Jit[V]) failed(kind JitEventKind, fun *Function[V], err error) {
+	j.stats.failed.Add(1)
+	j.event(JitEvent{Kind: kind, Name: fun.jitName(), Signature: signature(fun.MetaData), Err: err})
+}
+
+// compiled records and reports a compiled function
+func (j *Jit[V]) compiled(fun *Function[V], v *jitVariant[V], d time.Duration) {
+	sig := signature(fun.MetaData)
+	if v != nil {
+		j.stats.mutex.Lock()
+		j.stats.functions = append(j.stats.functions, jitFunctionRecord[V]{
+			name:        fun.jitName(),
+			signature:   sig,
+			compileTime: d,
+			callsBefore: fun.state.calls.Load(),
+			variant:     v,
+		})
+		j.stats.mutex.Unlock()
+	}
+	j.event(JitEvent{Kind: JitCompiled, Name: fun.jitName(), Signature: sig, Duration: d})
+}
+
+// Stats returns a snapshot of the statistics of the jit
+func (j *Jit[V]) Stats() JitStats {
+	j.stats.mutex.Lock()
+	defer j.stats.mutex.Unlock()
+	s := JitStats{
+		Queued:      j.stats.queued.Load(),
+		Compiled:    int64(len(j.stats.functions)),
+		Failed:      j.stats.failed.Load(),
+		CompileTime: time.Duration(j.stats.compileTime.Load()),
+		Functions:   make([]JitFunctionStats, len(j.stats.functions)),
+	}
+	for i, r := range j.stats.functions {
+		fs := JitFunctionStats{
+			Name:        r.name,
+			Signature:   r.signature,
+			CompileTime: r.compileTime,
+			CallsBefore: r.callsBefore,
+			CallsAfter:  r.variant.calls.Load(),
+			Deopts:      r.variant.deopts.Load(),
+			BailOuts:    r.variant.bailOuts.Load(),
+		}
+		if fs.BailOuts > 0 {
+			s.BailedOut++
+		}
+		s.Functions[i] = fs
+	}
+	return s
+}
+
+// jitName returns the name of the function used in events and statistics
+func (f *Function[V]) jitName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	if c, ok := f.Ast.(*parser2.ClosureLiteral); ok {
+		return "closure in line " + strconv.Itoa(int(c.Line))
+	}
+	return "closure"
+}
+
+// signature returns the argument types of the given meta data
+func signature(m *MetaData) string {
+	if m == nil {
+		return ""
+	}
+	types := make([]string, len(m.Parameters))
+	for i, p := range m.Parameters {
+		types[i] = p.Type
+	}
+	return strings.Join(types, ", ")
+}
@@ -0,0 +1,48 @@
+package funcGen
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilerOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("invokes the go compiler")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	_, err := compileSource(t.TempDir(), []byte("package main\n\nfunc f() int { return \"a\" }\n"))
+	assert.ErrorContains(t, err, "go build failed")
+	assert.ErrorContains(t, err, "cannot use \"a\"")
+}
+
+func TestJitEventString(t *testing.T) {
+	tests := []struct {
+		event JitEvent
+		want  string
+	}{
+		{JitEvent{Kind: JitQueued, Name: "f", Signature: "int, string"}, "queued f(int, string)"},
+		{JitEvent{Kind: JitCompiled, Name: "f", Signature: "int", Duration: time.Second}, "compiled f(int) in 1s"},
+		{JitEvent{Kind: JitFailed, Name: "f", Err: errors.New("oops")}, "failed f(): oops"},
+		{JitEvent{Kind: JitCacheHit, Path: "/tmp/a.so"}, "cache hit /tmp/a.so"},
+		{JitEvent{Kind: JitSource, Source: "package main"}, "source:\npackage main"},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			assert.Equal(t, test.want, test.event.String())
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	assert.Equal(t, "", signature(nil))
+	assert.Equal(t, "int, float64", signature(&MetaData{Parameters: []MetaDataParameter{
+		{Name: "a", Type: "int"},
+		{Name: "b", Type: "float64"},
+	}}))
+}
@@ -0,0 +1,252 @@
+package funcGen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	goParser "go/parser"
+	"go/token"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/hneemann/parser2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitIdent(t *testing.T) {
+	names := []string{"a", "my var", "my_var", "my__var", "my_u20_var", "ä", "a.b", "a\"b", "func", "_", "1", "", "x y"}
+	used := map[string]string{}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			id := jitIdent(name)
+			assert.True(t, token.IsIdentifier(id), id)
+			if other, ok := used[id]; ok {
+				t.Errorf("%q and %q are both mapped to %s", name, other, id)
+			}
+			used[id] = name
+		})
+	}
+}
+
+var trickyStrings = []string{
+	"",
+	"\"",
+	"\\",
+	"a\"+panic(\"x\")+\"b",
+	"`",
+	"line\nbreak",
+	"tab\there",
+	"null\x00byte",
+	"invalid \xff utf8",
+	"unicode äöü ☺",
+	" ",
+	"}; func init() { panic(1) }; var _ = {",
+}
+
+func TestJitConstantString(t *testing.T) {
+	j := &Jit[any]{}
+	for _, str := range trickyStrings {
+		t.Run(strconv.Quote(str), func(t *testing.T) {
+			code, typ, err := j.constant(str)
+			assert.NoError(t, err)
+			assert.Equal(t, jitString, typ)
+			lit, ok := parseExpr(t, code).(*ast.BasicLit)
+			assert.True(t, ok)
+			if ok {
+				s, err := strconv.Unquote(lit.Value)
+				assert.NoError(t, err)
+				assert.Equal(t, str, s)
+			}
+		})
+	}
+}
+
+func TestJitConstant(t *testing.T) {
+	j := &Jit[any]{}
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{1, "int(1)"},
+		{-1, "int(-1)"},
+		{math.MaxInt64, "int(9223372036854775807)"},
+		{math.MinInt64, "int(-9223372036854775808)"},
+		{1.5, "float64(1.5)"},
+		{1e300, "float64(1e+300)"},
+		{-2.5e-300, "float64(-2.5e-300)"},
+		{math.SmallestNonzeroFloat64, "float64(5e-324)"},
+		{true, "true"},
+		{[]any{1, "\""}, `[]any{int(1), "\""}`},
+		{map[string]any{"b\"": 1, "a b": 2.5}, `map[string]any{"a b": float64(2.5), "b\"": int(1)}`},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			code, _, err := j.constant(test.value)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, code)
+			parseExpr(t, code)
+		})
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1)} {
+		_, _, err := j.constant(f)
+		assert.Error(t, err)
+	}
+}
+
+func TestJitGenerateFunction(t *testing.T) {
+	j := &Jit[any]{ValueToUnderlying: func(v any) any { return v }}
+	var args []string
+	var params []MetaDataParameter
+	for i := 0; i < 12; i++ {
+		name := "my var " + strconv.Itoa(i)
+		args = append(args, name)
+		params = append(params, MetaDataParameter{Name: name, Type: jitString})
+	}
+	var body parser2.AST = &parser2.Ident{Name: args[0]}
+	for _, str := range trickyStrings {
+		body = &parser2.Operate{Operator: "+", A: body, B: &parser2.Const[any]{Value: str}}
+	}
+	body = &parser2.Let{
+		Name:  "a_b",
+		Value: &parser2.Const[any]{Value: "\""},
+		Inner: &parser2.Let{
+			Name:  "a b",
+			Value: &parser2.Ident{Name: args[11]},
+			Inner: &parser2.Operate{Operator: "+", A: body, B: &parser2.MapAccess{
+				Key:      "key \"with\" quotes",
+				MapValue: &parser2.Ident{Name: "a b"},
+			}},
+		},
+	}
+
+	var b bytes.Buffer
+	err := j.generateFunction(&b, "JIT_f", &parser2.ClosureLiteral{Names: args, Func: body}, "", &MetaData{Parameters: params})
+	assert.NoError(t, err)
+	src := append([]byte(jitPrelude+"\n"), b.Bytes()...)
+	_, err = format.Source(src)
+	assert.NoError(t, err, string(src))
+
+	file, err := goParser.ParseFile(token.NewFileSet(), "jit.go", src, 0)
+	assert.NoError(t, err)
+	// the strings are not able to inject any declarations
+	funcs := 0
+	for _, d := range file.Decls {
+		if f, ok := d.(*ast.FuncDecl); ok && f.Name.Name == "JIT_f" {
+			funcs++
+		}
+	}
+	assert.Equal(t, 1, funcs)
+	strs := map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			s, err := strconv.Unquote(lit.Value)
+			assert.NoError(t, err)
+			strs[s] = true
+		}
+		return true
+	})
+	for _, str := range trickyStrings {
+		assert.True(t, strs[str], str)
+	}
+	assert.True(t, strs["key \"with\" quotes"])
+}
+
+func parseExpr(t *testing.T, code string) ast.Expr {
+	expr, err := goParser.ParseExpr(code)
+	assert.NoError(t, err, code)
+	return expr
+}
+
+func TestJitSymbol(t *testing.T) {
+	assert.Equal(t, "JIT_fib", jitSymbol("fib", 3))
+	assert.Equal(t, "JIT_closure_L3", jitSymbol("", 3))
+	assert.Equal(t, "JIT_closure__L3", jitSymbol("closure_L3", 7))
+	assert.Equal(t, "JIT_my_u20_func", jitSymbol("my func", 1))
+	assert.True(t, token.IsIdentifier(jitSymbol("a.b c", 1)))
+}
+
+func fibAST(name string, n parser2.AST) parser2.AST {
+	call := func(d int) parser2.AST {
+		return &parser2.FunctionCall{
+			Func: &parser2.Ident{Name: name},
+			Args: []parser2.AST{&parser2.Operate{Operator: "-", A: n, B: &parser2.Const[any]{Value: d}}},
+		}
+	}
+	return &parser2.If{
+		Cond: &parser2.Operate{Operator: "<", A: n, B: &parser2.Const[any]{Value: 2}},
+		Then: n,
+		Else: &parser2.Operate{Operator: "+", A: call(1), B: call(2)},
+	}
+}
+
+func TestJitGenerateRecursive(t *testing.T) {
+	j := &Jit[any]{ValueToUnderlying: func(v any) any { return v }, g: New[any]()}
+	tests := []struct {
+		name string
+		typ  string
+		body parser2.AST
+		want []string
+	}{
+		{
+			name: "int",
+			typ:  jitInt,
+			body: fibAST("fib", &parser2.Ident{Name: "n"}),
+			want: []string{"func jitJIT_fib(jitSt any, v_n int) int {", "jitJIT_fib(jitSt, (v_n - int(1)))", "return jitJIT_fib(jitSt, v_n), nil"},
+		},
+		{
+			name: "float",
+			typ:  jitFloat,
+			body: fibAST("fib", &parser2.Ident{Name: "n"}),
+			want: []string{"func jitJIT_fib(jitSt any, v_n float64) float64 {", "jitJIT_fib(jitSt, (v_n - float64(int(1))))"},
+		},
+		{
+			name: "any",
+			typ:  jitAny,
+			body: fibAST("fib", &parser2.Ident{Name: "n"}),
+			want: []string{"func jitJIT_fib(jitSt any, v_n any) any {", "jitJIT_fib(jitSt, jitCheck(JIT_Operate(jitSt, \"-\", v_n, int(1))))"},
+		},
+		{
+			name: "argument type changes",
+			typ:  jitInt,
+			body: &parser2.If{
+				Cond: &parser2.Operate{Operator: ">", A: &parser2.Ident{Name: "n"}, B: &parser2.Const[any]{Value: 10}},
+				Then: &parser2.Const[any]{Value: 1},
+				Else: &parser2.FunctionCall{
+					Func: &parser2.Ident{Name: "fib"},
+					Args: []parser2.AST{&parser2.Operate{Operator: "*", A: &parser2.Ident{Name: "n"}, B: &parser2.Const[any]{Value: 1.5}}},
+				},
+			},
+			want: []string{"func jitJIT_fib(jitSt any, v_n int) any {", "jitCheck(JIT_fib(jitSt, (float64(v_n) * float64(1.5))))"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := j.generateFunction(&b, "JIT_fib", &parser2.ClosureLiteral{Names: []string{"n"}, Func: test.body}, "fib",
+				&MetaData{Parameters: []MetaDataParameter{{Name: "n", Type: test.typ}}})
+			assert.NoError(t, err)
+			_, err = format.Source(append([]byte(jitPrelude+"\n"), b.Bytes()...))
+			assert.NoError(t, err, b.String())
+			for _, w := range test.want {
+				assert.Contains(t, b.String(), w)
+			}
+		})
+	}
+}
+
+func TestJitGenerateShadowedSelf(t *testing.T) {
+	j := &Jit[any]{ValueToUnderlying: func(v any) any { return v }, g: New[any]()}
+	var b bytes.Buffer
+	// the argument shadows the function
+	err := j.generateFunction(&b, "JIT_f", &parser2.ClosureLiteral{Names: []string{"f"}, Func: &parser2.Ident{Name: "f"}}, "f",
+		&MetaData{Parameters: []MetaDataParameter{{Name: "f", Type: jitInt}}})
+	assert.NoError(t, err)
+	assert.Contains(t, b.String(), "func jitJIT_f(jitSt any, v_f int) int {\n\treturn v_f\n}")
+
+	// the function used as a value is not supported
+	err = j.generateFunction(&b, "JIT_f", &parser2.ClosureLiteral{Names: []string{"x"}, Func: &parser2.Ident{Name: "f"}}, "f",
+		&MetaData{Parameters: []MetaDataParameter{{Name: "x", Type: jitInt}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,176 @@
+package funcGen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/hneemann/parser2"
+)
+
+// ErrStepLimit is the cause of a StoppedError if the step budget is exhausted
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// StoppedError is returned if an evaluation started by EvalContext is
+// stopped, because the context is done or the step budget is exhausted.
+type StoppedError struct {
+	// Cause is the error of the context or ErrStepLimit
+	Cause error
+	// Steps is the number of steps evaluated until the evaluation was stopped
+	Steps int64
+	// Function is the name of the innermost function that was evaluated
+	// when the evaluation was stopped, empty if the function has no name
+	Function string
+	// Line is the line of the innermost function that was evaluated when
+	// the evaluation was stopped, zero if the evaluation was stopped outside
+	// of a function
+	Line parser2.Line
+}
+
+func (e *StoppedError) Error() string {
+	m := fmt.Sprintf("evaluation stopped after %d steps", e.Steps)
+	if e.Function != "" {
+		m += " in function " + e.Function
+	}
+	if e.Line > 0 {
+		m += fmt.Sprintf(" in line %d", e.Line)
+	}
+	return m + ": " + e.Cause.Error()
+}
+
+func (e *StoppedError) Unwrap() error {
+	return e.Cause
+}
+
+type stepLimitKey struct{}
+
+// WithStepLimit returns a context which limits the number of steps of an
+// evaluation started by EvalContext. Steps are the calls of closures and the
+// iteration steps of lists.
+func WithStepLimit(ctx context.Context, maxSteps int64) context.Context {
+	return context.WithValue(ctx, stepLimitKey{}, maxSteps)
+}
+
+// evalLimit holds the limits of an evaluation. It is shared by all stacks
+// used in an evaluation.
+type evalLimit struct {
+	ctx      context.Context
+	done     <-chan struct{}
+	maxSteps int64
+	steps    atomic.Int64
+}
+
+func newEvalLimit(ctx context.Context) *evalLimit {
+	l := &evalLimit{ctx: ctx, done: ctx.Done()}
+	if m, ok := ctx.Value(stepLimitKey{}).(int64); ok {
+		l.maxSteps = m
+	}
+	return l
+}
+
+func (l *evalLimit) step() error {
+	n := l.steps.Add(1)
+	if l.maxSteps > 0 && n > l.maxSteps {
+		return &StoppedError{Cause: ErrStepLimit, Steps: n - 1}
+	}
+	if l.done != nil {
+		select {
+		case <-l.done:
+			return &StoppedError{Cause: l.ctx.Err(), Steps: n - 1}
+		default:
+		}
+	}
+	return nil
+}
+
+// EvalContext evaluates the function like Eval. The evaluation is stopped
+// if the context is done or if the step budget set by WithStepLimit is
+// exhausted. In this case a *StoppedError is returned. While the evaluation
+// is limited, jit compiled functions are not used because they can not be
+// stopped.
+func (f Func[V]) EvalContext(ctx context.Context, args ...V) (V, error) {
+	st := NewEmptyStack[V]().Init(args...)
+	st.storage.limit = newEvalLimit(ctx)
+	if err := st.Step(); err != nil {
+		var zero V
+		return zero, err
+	}
+	return f(st)
+}
+
+// Step is called at each step of an evaluation, like the iteration step
+// of a list. If the evaluation is limited and the limit is exceeded, an
+// error is returned.
+func (s Stack[V]) Step() error {
+	if l := s.limit(); l != nil {
+		return l.step()
+	}
+	return nil
+}
+
+// Limited returns true if the evaluation s belongs to is limited
+func (s Stack[V]) Limited() bool {
+	return s.limit() != nil
+}
+
+// New creates a new empty stack which shares the limits and the state of
+// the evaluation s belongs to. It is used if a function is called in another
+// goroutine.
+func (s Stack[V]) New() Stack[V] {
+	n := NewEmptyStack[V]()
+	n.storage.limit = s.limit()
+	if s.storage != nil {
+		n.storage.state.Store(s.state())
+	}
+	return n
+}
+
+// Context returns the context of the evaluation s belongs to. If the
+// evaluation was not started by EvalContext, the background context is
+// returned.
+func (s Stack[V]) Context() context.Context {
+	if l := s.limit(); l != nil {
+		return l.ctx
+	}
+	return context.Background()
+}
+
+func (s Stack[V]) limit() *evalLimit {
+	if s.storage == nil {
+		return nil
+	}
+	return s.storage.limit
+}
+
+// callLimited calls the function if the evaluation is limited. The
+// compiled variants are not used because they can not be stopped.
+// If the evaluation is stopped before the function is entered, the
+// location is added by the caller, like a stop at an iteration step.
+func (f *Function[V]) callLimited(l *evalLimit, frame Stack[V], cs []V) (V, error) {
+	if err := l.step(); err != nil {
+		var zero V
+		return zero, err
+	}
+	if s := f.state; s != nil && s.call(f) {
+		f.queue(frame.ToSlice()...)
+	}
+	v, err := f.Func(frame, cs)
+	if err != nil {
+		err = f.stoppedIn(err)
+	}
+	return v, err
+}
+
+// stoppedIn adds the location of the function to a StoppedError, if
+// not already done by an inner function.
+func (f *Function[V]) stoppedIn(err error) error {
+	var se *StoppedError
+	if errors.As(err, &se) && se.Line == 0 {
+		if c, ok := f.Ast.(*parser2.ClosureLiteral); ok {
+			se.Line = c.Line
+			se.Function = f.Name
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package funcGen
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalContext(t *testing.T) {
+	fg := NewGen()
+
+	f, err := fg.Generate("func f(x) x*2;\nf(a)+f(a+1)", "a")
+	assert.NoError(t, err)
+	res, err := f.EvalContext(WithStepLimit(context.Background(), 10), Float(2))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(10), res)
+
+	f, err = fg.Generate("func f(x)\n  f(x+1);\nf(a)", "a")
+	assert.NoError(t, err)
+	_, err = f.EvalContext(WithStepLimit(context.Background(), 100), Float(2))
+	var se *StoppedError
+	assert.True(t, errors.As(err, &se))
+	assert.ErrorIs(t, err, ErrStepLimit)
+	assert.EqualValues(t, 100, se.Steps)
+	assert.Equal(t, "f", se.Function)
+	assert.EqualValues(t, 1, se.Line)
+
+	// stopped before f is entered, so the location is the caller
+	f2, err := fg.Generate("func f(x)\n  x*2;\nf(a)", "a")
+	assert.NoError(t, err)
+	_, err = f2.EvalContext(WithStepLimit(context.Background(), 1), Float(2))
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, "", se.Function)
+	assert.EqualValues(t, 0, se.Line)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.EvalContext(ctx, Float(2))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, errors.As(err, &se))
+}
+
+func TestStackLimit(t *testing.T) {
+	st := NewEmptyStack[Value]()
+	assert.False(t, st.Limited())
+	assert.NoError(t, st.Step())
+	assert.Equal(t, context.Background(), st.Context())
+
+	ctx := WithStepLimit(context.Background(), 1)
+	st.storage.limit = newEvalLimit(ctx)
+	n := st.New()
+	assert.True(t, n.Limited())
+	assert.Equal(t, ctx, n.Context())
+	assert.NoError(t, n.Step())
+	assert.ErrorIs(t, st.Step(), ErrStepLimit)
+}
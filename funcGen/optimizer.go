@@ -1,6 +1,9 @@
 package funcGen
 
 import (
+	"context"
+	"errors"
+
 	"github.com/hneemann/parser2"
 	"github.com/hneemann/parser2/listMap"
 )
@@ -126,9 +129,123 @@ func (o optimizer[V]) Optimize(ast parser2.AST) (parser2.AST, error) {
 			}
 		}
 	}
+	// evaluate pure method calls on constants like "abc".toUpper()
+	if mc, ok := ast.(*parser2.MethodCall); ok && o.g.methodHandler != nil {
+		if value, ok := o.isConst(mc.Value); ok && !o.isClosureField(value, mc.Name) {
+			if args, ok, err := o.constArgs(mc.Args); ok {
+				me, err := o.g.methodHandler.GetMethod(value, mc.Name)
+				if err != nil {
+					return nil, mc.EnhanceErrorf(err, "error accessing method %s", mc.Name)
+				}
+				if me.IsPure {
+					if me.Args > 0 && me.Args != len(args)+1 {
+						return nil, mc.Errorf("wrong number of arguments at call of \"%s\", required %d, found %d", me.Description.String(mc.Name), me.Args-1, len(args))
+					}
+					v, err := me.Func(newFoldStack(append([]V{value}, args...)...), nil)
+					if err != nil {
+						var se *StoppedError
+						if errors.As(err, &se) {
+							// too expensive, the call is left to the evaluation
+							return nil, nil
+						}
+						return nil, mc.EnhanceErrorf(err, "error in constant pre evaluation of method: %s", mc.Name)
+					}
+					return &parser2.Const[V]{v, mc.Line}, nil
+				}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return nil, nil
 }
 
+// isClosureField returns true if the value is a map which contains
+// a field with the given name. In this case the method call is a call of the
+// closure stored in the map.
+func (o optimizer[V]) isClosureField(value V, name string) bool {
+	if o.g.mapHandler != nil && o.g.mapHandler.IsMap(value) {
+		_, err := o.g.mapHandler.AccessMap(value, name)
+		return err == nil
+	}
+	return false
+}
+
+// maxFoldSteps is the number of steps a method call may take to be
+// evaluated by the optimizer. If more steps are required, like iterating
+// a large list, the call is left to the evaluation.
+const maxFoldSteps = 10000
+
+// newFoldStack creates the stack used to evaluate method calls in the
+// optimizer. The stack limits the number of steps to maxFoldSteps.
+func newFoldStack[V any](v ...V) Stack[V] {
+	st := NewStack[V](v...)
+	st.storage.limit = newEvalLimit(WithStepLimit(context.Background(), maxFoldSteps))
+	return st
+}
+
+// constArgs returns the values of the given method arguments if all of them
+// are constant. A closure literal is also considered to be constant if it
+// does not access outer values and its evaluation can not cause side effects.
+func (o optimizer[V]) constArgs(asts []parser2.AST) ([]V, bool, error) {
+	con := make([]V, len(asts))
+	for i, ast := range asts {
+		if c, ok := o.isConst(ast); ok {
+			con[i] = c
+		} else if cl, ok := ast.(*parser2.ClosureLiteral); ok && o.g.closureHandler != nil && o.isPureClosure(cl) {
+			f, err := o.g.GenerateFunc(cl, GeneratorContext{am: argsMap{}})
+			if err != nil {
+				return nil, false, err
+			}
+			c, err := f(NewEmptyStack[V](), nil)
+			if err != nil {
+				return nil, false, err
+			}
+			// the closure is only called while folding, so it is not
+			// passed to the jit
+			if fu, ok := o.g.closureHandler.ToClosure(c); ok {
+				fu.JitCompiler = nil
+				fu.state = nil
+				c = o.g.closureHandler.FromClosure(fu)
+			}
+			con[i] = c
+		} else {
+			return nil, false, nil
+		}
+	}
+	return con, true, nil
+}
+
+// isPureClosure returns true if the closure does not access outer values
+// and only calls pure static functions or functions passed as arguments.
+// Method calls are not allowed because the type of the receiver is unknown.
+func (o optimizer[V]) isPureClosure(cl *parser2.ClosureLiteral) bool {
+	if cl.Memo || len(o.g.checkIfClosure(cl, argsMap{})) > 0 {
+		return false
+	}
+	pure := true
+	cl.Func.Traverse(visitorFunc(func(ast parser2.AST) bool {
+		switch a := ast.(type) {
+		case *parser2.MethodCall:
+			pure = false
+		case *parser2.FunctionCall:
+			if id, ok := a.Func.(*parser2.Ident); ok {
+				if fu, ok := o.g.staticFunctions[id.Name]; ok && !fu.IsPure {
+					pure = false
+				}
+			}
+		}
+		return pure
+	}))
+	return pure
+}
+
+type visitorFunc func(parser2.AST) bool
+
+func (v visitorFunc) Visit(ast parser2.AST) bool {
+	return v(ast)
+}
+
 func (o optimizer[V]) allConst(asts []parser2.AST) ([]V, bool) {
 	con := make([]V, len(asts))
 	for i, ast := range asts {
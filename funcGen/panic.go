@@ -0,0 +1,101 @@
+package funcGen
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/hneemann/parser2"
+)
+
+// PanicHandling defines how panics raised during an evaluation are handled
+type PanicHandling int
+
+const (
+	// RecoverPanics converts panics into a *PanicError. This is the default.
+	RecoverPanics PanicHandling = iota
+	// RecoverPanicsWithStack converts panics into a *PanicError which also
+	// contains the go stack trace of the panic.
+	RecoverPanicsWithStack
+	// PropagatePanics does not recover panics, so that they reach the
+	// caller of the function. This is useful while debugging.
+	PropagatePanics
+)
+
+// PanicError is returned if a panic was raised during an evaluation
+type PanicError struct {
+	// Value is the value passed to panic
+	Value any
+	// Function is the name of the go function or method which raised the
+	// panic, empty if the panic was raised outside of such a function
+	Function string
+	// Line is the line of the call of the function
+	Line parser2.Line
+	// Stack is the go stack trace of the panic, only set if
+	// RecoverPanicsWithStack is used
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	m := "panic"
+	if e.Function != "" {
+		m += " in function " + e.Function
+	}
+	if e.Line > 0 {
+		m += fmt.Sprintf(" in line %d", e.Line)
+	}
+	m += fmt.Sprintf(": %v", e.Value)
+	if len(e.Stack) > 0 {
+		m += "\n" + string(e.Stack)
+	}
+	return m
+}
+
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// SetPanicHandling sets how panics raised during an evaluation are handled
+func (g *FunctionGenerator[V]) SetPanicHandling(panicHandling PanicHandling) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.panicHandling = panicHandling
+	return g
+}
+
+// panicError creates the error describing the recovered panic
+func (g *FunctionGenerator[V]) panicError(rec any, name string, line parser2.Line) error {
+	pe := &PanicError{Value: rec, Function: name, Line: line}
+	if g.panicHandling == RecoverPanicsWithStack {
+		pe.Stack = debug.Stack()
+	}
+	return pe
+}
+
+// callGo calls a go function like a static function or a method. A panic
+// raised by the function is converted to an error if required. If an error
+// occurs, the function is added to the stack trace.
+func (g *FunctionGenerator[V]) callGo(f ParserFunc[V], st Stack[V], name string, line parser2.Line) (v V, err error) {
+	if g.panicHandling == PropagatePanics {
+		v, err = f(st, nil)
+	} else {
+		v, err = g.callRecover(f, st, name, line)
+	}
+	if err != nil {
+		err = goFrame(err, st, name, line)
+	}
+	return v, err
+}
+
+// callRecover calls the function and converts a panic to an error
+func (g *FunctionGenerator[V]) callRecover(f ParserFunc[V], st Stack[V], name string, line parser2.Line) (v V, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			var zero V
+			v = zero
+			err = g.panicError(rec, name, line)
+		}
+	}()
+	return f(st, nil)
+}
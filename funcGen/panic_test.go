@@ -0,0 +1,75 @@
+package funcGen
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Trap is used to test the recovery of panics raised by methods
+func (f Float) Trap() Float {
+	var m map[string]int
+	m["a"] = int(f)
+	return f
+}
+
+func panicGen() *FunctionGenerator[Value] {
+	return NewGen().
+		AddGoFunction("boom", 1, func(a ...Value) (Value, error) {
+			panic("boom called")
+		}).
+		AddGoFunction("call", 1, func(a ...Value) (Value, error) {
+			f, _ := th.ToClosure(a[0])
+			return f.Eval(NewEmptyStack[Value](), Float(1))
+		})
+}
+
+func TestPanicRecovery(t *testing.T) {
+	tests := []struct {
+		exp      string
+		function string
+		line     int
+	}{
+		{exp: "1+\nboom(a)", function: "boom", line: 2},
+		{exp: "let x=a*2;\n\nx.trap()", function: "trap", line: 3},
+		{exp: "call(x->\n  boom(x))", function: "boom", line: 2},
+	}
+	for _, test := range tests {
+		f, err := panicGen().Generate(test.exp, "a")
+		assert.NoError(t, err)
+		_, err = f.Eval(Float(1))
+		var pe *PanicError
+		if assert.True(t, errors.As(err, &pe), err) {
+			assert.Equal(t, test.function, pe.Function)
+			assert.EqualValues(t, test.line, pe.Line)
+			assert.Nil(t, pe.Stack)
+		}
+	}
+
+	f, err := panicGen().SetPanicHandling(RecoverPanicsWithStack).Generate("boom(a)", "a")
+	assert.NoError(t, err)
+	_, err = f.Eval(Float(1))
+	var pe *PanicError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, "boom called", pe.Value)
+		assert.Contains(t, string(pe.Stack), "panic_test.go")
+	}
+
+	f, err = panicGen().SetPanicHandling(PropagatePanics).Generate("boom(a)", "a")
+	assert.NoError(t, err)
+	assert.PanicsWithValue(t, "boom called", func() {
+		f.Eval(Float(1))
+	})
+}
+
+func TestPanicError(t *testing.T) {
+	cause := errors.New("cause")
+	err := &PanicError{Value: cause, Function: "f", Line: 3}
+	assert.Equal(t, "panic in function f in line 3: cause", err.Error())
+	assert.ErrorIs(t, err, cause)
+
+	err = &PanicError{Value: 1}
+	assert.Equal(t, "panic: 1", err.Error())
+	assert.Nil(t, err.Unwrap())
+}
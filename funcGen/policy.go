@@ -0,0 +1,180 @@
+package funcGen
+
+import (
+	"fmt"
+
+	"github.com/hneemann/parser2"
+)
+
+// The language constructs which can be restricted by a Policy
+const (
+	// ConstructClosure is the creation of a closure, including function definitions
+	ConstructClosure = "closure"
+	// ConstructRecursion is a function which calls itself. Detected are
+	// functions calling themselves by name and functions which are passed
+	// to themselves as an argument like f(f,x). Recursion which is hidden
+	// otherwise, e.g. by storing the function in a list, is not detected,
+	// so the evaluation should also be limited by EvalContext.
+	ConstructRecursion = "recursion"
+	// ConstructMemo is a memoized function declared by 'memo func'
+	ConstructMemo = "memo"
+	// ConstructTryCatch is a try-catch expression
+	ConstructTryCatch = "try"
+	// ConstructSwitch is a switch expression
+	ConstructSwitch = "switch"
+	// ConstructList is a list literal
+	ConstructList = "list"
+	// ConstructMap is a map literal
+	ConstructMap = "map"
+)
+
+// Rule decides whether a name is permitted. A nil rule permits all names.
+type Rule func(name string) bool
+
+// Allow creates a rule which permits only the given names
+func Allow(names ...string) Rule {
+	set := toSet(names)
+	return func(name string) bool {
+		return set[name]
+	}
+}
+
+// Deny creates a rule which permits all names except the given ones
+func Deny(names ...string) Rule {
+	set := toSet(names)
+	return func(name string) bool {
+		return !set[name]
+	}
+}
+
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return set
+}
+
+func (r Rule) permits(name string) bool {
+	return r == nil || r(name)
+}
+
+// Policy restricts the capabilities available to the scripts. The policy
+// is checked by Generate before the expression is optimized, so that
+// violations are reported before anything is evaluated.
+type Policy struct {
+	// StaticFunctions are the static functions which can be called
+	StaticFunctions Rule
+	// Methods are the names of the methods which can be called
+	Methods Rule
+	// Operators are the binary and unary operators which can be used
+	Operators Rule
+	// Constructs are the language constructs which can be used,
+	// see the Construct constants like ConstructClosure
+	Constructs Rule
+}
+
+// PolicyError is returned by Generate if an expression violates the policy
+type PolicyError struct {
+	// Kind describes what is not allowed, e.g. "function" or "method"
+	Kind string
+	// Name is the name of the function, method, operator or construct
+	Name string
+	// Line is the line of the violation
+	Line parser2.Line
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s '%s' is not allowed in line %d", e.Kind, e.Name, e.Line)
+}
+
+// GetLine returns the line of the violation
+func (e *PolicyError) GetLine() parser2.Line {
+	return e.Line
+}
+
+// SetPolicy sets the policy which restricts the capabilities available
+// to the scripts.
+func (g *FunctionGenerator[V]) SetPolicy(policy Policy) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.policy = &policy
+	return g
+}
+
+// checkPolicy checks whether the AST violates the policy
+func (g *FunctionGenerator[V]) checkPolicy(ast parser2.AST) error {
+	if g.policy == nil {
+		return nil
+	}
+	pc := policyChecker[V]{g: g, p: g.policy}
+	ast.Traverse(&pc)
+	return pc.err
+}
+
+type policyChecker[V any] struct {
+	g   *FunctionGenerator[V]
+	p   *Policy
+	err error
+}
+
+func (pc *policyChecker[V]) check(rule Rule, kind, name string, line parser2.Line) bool {
+	if pc.err == nil && !rule.permits(name) {
+		pc.err = &PolicyError{Kind: kind, Name: name, Line: line}
+	}
+	return pc.err == nil
+}
+
+func (pc *policyChecker[V]) construct(name string, line parser2.Line) bool {
+	return pc.check(pc.p.Constructs, "construct", name, line)
+}
+
+func (pc *policyChecker[V]) Visit(ast parser2.AST) bool {
+	switch a := ast.(type) {
+	case *parser2.FunctionCall:
+		if id, ok := a.Func.(*parser2.Ident); ok {
+			if _, ok := pc.g.staticFunctions[id.Name]; ok {
+				return pc.check(pc.p.StaticFunctions, "function", id.Name, a.Line)
+			}
+			if isSelfApplication(id.Name, a.Args) {
+				return pc.construct(ConstructRecursion, a.Line)
+			}
+		}
+	case *parser2.MethodCall:
+		return pc.check(pc.p.Methods, "method", a.Name, a.Line)
+	case *parser2.Operate:
+		return pc.check(pc.p.Operators, "operator", a.Operator, a.Line)
+	case *parser2.Unary:
+		return pc.check(pc.p.Operators, "operator", a.Operator, a.Line)
+	case *parser2.ClosureLiteral:
+		if a.Memo && !pc.construct(ConstructMemo, a.Line) {
+			return false
+		}
+		return pc.construct(ConstructClosure, a.Line)
+	case *parser2.Let:
+		if _, ok := a.Value.(*parser2.ClosureLiteral); ok {
+			if _, ok := pc.g.checkIfClosure(a, argsMap{})[a.Name]; ok {
+				return pc.construct(ConstructRecursion, a.Line)
+			}
+		}
+	case *parser2.TryCatch:
+		return pc.construct(ConstructTryCatch, a.Line)
+	case *parser2.Switch[V]:
+		return pc.construct(ConstructSwitch, a.Line)
+	case *parser2.ListLiteral:
+		return pc.construct(ConstructList, a.Line)
+	case *parser2.MapLiteral:
+		return pc.construct(ConstructMap, a.Line)
+	}
+	return pc.err == nil
+}
+
+// isSelfApplication returns true if the function with the given name
+// is passed to itself as an argument
+func isSelfApplication(name string, args []parser2.AST) bool {
+	for _, arg := range args {
+		if id, ok := arg.(*parser2.Ident); ok && id.Name == name {
+			return true
+		}
+	}
+	return false
+}
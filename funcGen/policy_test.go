@@ -0,0 +1,49 @@
+package funcGen
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		exp    string
+		err    *PolicyError
+	}{
+		{name: "allowed function", policy: Policy{StaticFunctions: Allow("sqr")}, exp: "sqr(a)"},
+		{name: "function", policy: Policy{StaticFunctions: Allow("sqr")}, exp: "1+\nsqrt(a)", err: &PolicyError{Kind: "function", Name: "sqrt", Line: 2}},
+		{name: "const function", policy: Policy{StaticFunctions: Deny("sqrt")}, exp: "sqrt(2)", err: &PolicyError{Kind: "function", Name: "sqrt", Line: 1}},
+		{name: "closure call", policy: Policy{StaticFunctions: Allow()}, exp: "let f=x->x*x;f(a)"},
+		{name: "operator", policy: Policy{Operators: Deny("*")}, exp: "a+2*3", err: &PolicyError{Kind: "operator", Name: "*", Line: 1}},
+		{name: "unary", policy: Policy{Operators: Allow("+", "*")}, exp: "-a", err: &PolicyError{Kind: "operator", Name: "-", Line: 1}},
+		{name: "method", policy: Policy{Methods: Deny("map")}, exp: "[a].map(x->x).size()", err: &PolicyError{Kind: "method", Name: "map", Line: 1}},
+		{name: "closure", policy: Policy{Constructs: Deny(ConstructClosure)}, exp: "let f=x->x*x;f(a)", err: &PolicyError{Kind: "construct", Name: "closure", Line: 1}},
+		{name: "no recursion", policy: Policy{Constructs: Deny(ConstructRecursion)}, exp: "let f=x->x*x;f(a)"},
+		{name: "recursion", policy: Policy{Constructs: Deny(ConstructRecursion)}, exp: "func f(x) if x then x*f(x+-1) else 1;\nf(a)", err: &PolicyError{Kind: "construct", Name: "recursion", Line: 1}},
+		{name: "self application", policy: Policy{Constructs: Deny(ConstructRecursion)}, exp: "let f=(g,x)->if x then g(g,x+-1) else 0;\nf(f,a)", err: &PolicyError{Kind: "construct", Name: "recursion", Line: 1}},
+		{name: "self application call", policy: Policy{Constructs: Deny(ConstructRecursion)}, exp: "let f=(g,x)->x;\nf(f,a)", err: &PolicyError{Kind: "construct", Name: "recursion", Line: 2}},
+		{name: "try", policy: Policy{Constructs: Deny(ConstructTryCatch)}, exp: "try a catch 1", err: &PolicyError{Kind: "construct", Name: "try", Line: 1}},
+		{name: "list", policy: Policy{Constructs: Deny(ConstructList)}, exp: "[a]", err: &PolicyError{Kind: "construct", Name: "list", Line: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewGen().
+				AddSimpleFunction("sqr", func(v Value) Value { return v }).
+				AddSimpleFunction("sqrt", func(v Value) Value { return v }).
+				SetPolicy(test.policy).
+				Generate(test.exp, "a")
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				var pe *PolicyError
+				if assert.True(t, errors.As(err, &pe), err) {
+					assert.Equal(t, test.err, pe)
+				}
+			}
+		})
+	}
+}
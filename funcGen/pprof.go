@@ -0,0 +1,161 @@
+package funcGen
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// protoBuffer is a minimal protocol buffer encoder used to create pprof
+// profiles without depending on the pprof packages.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.varint(uint64(field<<3 | wireType))
+}
+
+func (b *protoBuffer) int(field int, v int64) {
+	if v != 0 {
+		b.tag(field, 0)
+		b.varint(uint64(v))
+	}
+}
+
+func (b *protoBuffer) bytes(field int, d []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(d)))
+	b.data = append(b.data, d...)
+}
+
+func (b *protoBuffer) packed(field int, v []int64) {
+	var p protoBuffer
+	for _, e := range v {
+		p.varint(uint64(e))
+	}
+	b.bytes(field, p.data)
+}
+
+func (b *protoBuffer) message(field int, m func(b *protoBuffer)) {
+	var p protoBuffer
+	m(&p)
+	b.bytes(field, p.data)
+}
+
+// stringTable collects the strings of a pprof profile
+type stringTable struct {
+	index   map[string]int64
+	strings []string
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{index: map[string]int64{"": 0}, strings: []string{""}}
+}
+
+func (t *stringTable) get(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.index[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+// pprofFile is the file name used in the frames of the profile
+const pprofFile = "script"
+
+// WritePprof writes the collected data as a gzip compressed pprof profile.
+// Each profiled node of the script is a frame, so that the profile can be
+// analyzed with 'go tool pprof'.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	st := newStringTable()
+	var b protoBuffer
+
+	type valueType struct{ typ, unit string }
+	sampleTypes := []valueType{{"calls", "count"}, {"time", "nanoseconds"}}
+	if p.allocs {
+		sampleTypes = append(sampleTypes, valueType{"alloc_space", "bytes"}, valueType{"alloc_objects", "count"})
+	}
+	for _, t := range sampleTypes {
+		b.message(1, func(b *protoBuffer) {
+			b.int(1, st.get(t.typ))
+			b.int(2, st.get(t.unit))
+		})
+	}
+
+	nodes := map[*profileNode]bool{}
+	var nodeList []*profileNode
+	p.walk(func(stack []*callNode) {
+		c := stack[len(stack)-1]
+		if !nodes[c.node] {
+			nodes[c.node] = true
+			nodeList = append(nodeList, c.node)
+		}
+		if c.calls == 0 {
+			return
+		}
+		locations := make([]int64, len(stack))
+		for i, s := range stack {
+			locations[len(stack)-1-i] = int64(s.node.id)
+		}
+		values := []int64{c.calls, c.time.Nanoseconds()}
+		if p.allocs {
+			values = append(values, c.allocBytes, c.allocObjects)
+		}
+		b.message(2, func(b *protoBuffer) {
+			b.packed(1, locations)
+			b.packed(2, values)
+		})
+	})
+
+	// each node is a location and a function with the same id
+	for _, n := range nodeList {
+		b.message(4, func(b *protoBuffer) {
+			b.int(1, int64(n.id))
+			b.message(4, func(b *protoBuffer) {
+				b.int(1, int64(n.id))
+				b.int(2, int64(n.line))
+			})
+		})
+	}
+	for _, n := range nodeList {
+		b.message(5, func(b *protoBuffer) {
+			b.int(1, int64(n.id))
+			b.int(2, st.get(n.frameName()))
+			b.int(3, st.get(n.frameName()))
+			b.int(4, st.get(pprofFile))
+			b.int(5, int64(n.line))
+		})
+	}
+
+	b.int(9, p.start.UnixNano())
+	b.int(10, time.Since(p.start).Nanoseconds())
+	b.message(11, func(b *protoBuffer) {
+		b.int(1, st.get("time"))
+		b.int(2, st.get("nanoseconds"))
+	})
+	b.int(14, st.get("time"))
+	// the string table is written last, because all strings are known now
+	for _, s := range st.strings {
+		b.bytes(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,384 @@
+package funcGen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hneemann/parser2"
+)
+
+// Profiler collects the execution time, the number of calls and optionally
+// the allocations of the nodes of the AST. The nodes are recorded in a call
+// tree, so that the results can be exported as a pprof profile or as folded
+// stacks used to create flame graphs. The script lines are used as frames.
+// Functions evaluated with a new stack, like the functions called by the list
+// operations which may run concurrently, start a new call tree.
+type Profiler struct {
+	mutex  sync.Mutex
+	allocs bool
+	nodes  map[profileKey]*profileNode
+	// names holds the names of the closures assigned to a variable by let
+	names map[*parser2.ClosureLiteral]string
+	root  *callNode
+	start time.Time
+}
+
+// profileKey identifies a profiled node. A closure literal is profiled as
+// the creation of the closure and as the call of the closure.
+type profileKey struct {
+	ast  parser2.AST
+	call bool
+}
+
+// profileNode is a profiled node of the AST
+type profileNode struct {
+	id   int
+	name string
+	line parser2.Line
+}
+
+// callNode is a node of the call tree
+type callNode struct {
+	node     *profileNode
+	children map[*profileNode]*callNode
+	calls    int64
+	// time and allocations without the children
+	time         time.Duration
+	allocBytes   int64
+	allocObjects int64
+}
+
+// profileState holds the current position in the call tree of an evaluation
+type profileState struct {
+	current      *callNode
+	childTime    time.Duration
+	childBytes   int64
+	childObjects int64
+}
+
+// NodeProfile is the profile of a node of the AST
+type NodeProfile struct {
+	// Name describes the node, e.g. the name of the called function
+	Name string
+	// Line is the source line of the node
+	Line parser2.Line
+	// Calls is the number of evaluations of the node
+	Calls int64
+	// Time is the time spent in the node including the called nodes
+	Time time.Duration
+	// SelfTime is the time spent in the node itself
+	SelfTime time.Duration
+	// AllocBytes is the number of bytes allocated by the node itself
+	AllocBytes int64
+	// AllocObjects is the number of objects allocated by the node itself
+	AllocObjects int64
+}
+
+// LineProfile is the profile of a source line
+type LineProfile struct {
+	Line parser2.Line
+	// Time is the time spent in the nodes of the line without the
+	// called nodes
+	Time time.Duration
+	// AllocBytes is the number of bytes allocated by the nodes of the line
+	AllocBytes int64
+	// AllocObjects is the number of objects allocated by the nodes of the line
+	AllocObjects int64
+}
+
+// NewProfiler creates a new profiler. If allocs is true, also the allocations
+// are measured. This slows down the evaluation considerably and the results
+// are only approximations, because the heap statistics of the runtime are
+// used, which also contain the allocations of other goroutines.
+func NewProfiler(allocs bool) *Profiler {
+	p := &Profiler{
+		allocs: allocs,
+		nodes:  map[profileKey]*profileNode{},
+		names:  map[*parser2.ClosureLiteral]string{},
+	}
+	p.Reset()
+	return p
+}
+
+// SetProfiler sets the profiler used to profile the generated functions.
+// If a profiler is set, functions are not jit compiled.
+func (g *FunctionGenerator[V]) SetProfiler(profiler *Profiler) *FunctionGenerator[V] {
+	g.CheckNotFrozen()
+	g.profiler = profiler
+	return g
+}
+
+// Reset removes all collected data
+func (p *Profiler) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.root = &callNode{children: map[*profileNode]*callNode{}}
+	p.start = time.Now()
+}
+
+// nameClosure records the name of a closure assigned to a variable by let
+func (p *Profiler) nameClosure(ast parser2.AST) {
+	if l, ok := ast.(*parser2.Let); ok {
+		if c, ok := l.Value.(*parser2.ClosureLiteral); ok {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			p.names[c] = l.Name
+		}
+	}
+}
+
+// node returns the profiled node of the given AST node
+func (p *Profiler) node(ast parser2.AST, call *parser2.ClosureLiteral) *profileNode {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := profileKey{ast: ast}
+	if call != nil {
+		key = profileKey{ast: call, call: true}
+	}
+	if n, ok := p.nodes[key]; ok {
+		return n
+	}
+	n := &profileNode{id: len(p.nodes) + 1, name: profileName(ast), line: ast.GetLine()}
+	if call != nil {
+		n.name = "func"
+		if name := closureName(call, p.names[call]); name != "" {
+			n.name += " " + name
+		}
+		n.line = call.Line
+	}
+	p.nodes[key] = n
+	return n
+}
+
+// profileName returns the name of a node used in the profile
+func profileName(ast parser2.AST) string {
+	switch a := ast.(type) {
+	case *parser2.FunctionCall:
+		if id, ok := a.Func.(*parser2.Ident); ok {
+			return id.Name + "()"
+		}
+		return "call"
+	case *parser2.MethodCall:
+		return "." + a.Name + "()"
+	case *parser2.MapAccess:
+		return "." + a.Key
+	case *parser2.Operate:
+		return a.Operator
+	case *parser2.Unary:
+		return a.Operator
+	case *parser2.Let:
+		return "let " + a.Name
+	case *parser2.If:
+		return "if"
+	case *parser2.TryCatch:
+		return "try"
+	case *parser2.ListAccess:
+		return "[]"
+	case *parser2.ListLiteral:
+		return "list"
+	case *parser2.MapLiteral:
+		return "map"
+	case *parser2.ClosureLiteral:
+		return "closure"
+	}
+	name := fmt.Sprintf("%T", ast)
+	name = name[strings.LastIndex(name, ".")+1:]
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// enter returns the child of the given call node
+func (p *Profiler) enter(parent *callNode, n *profileNode) *callNode {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c, ok := parent.children[n]
+	if !ok {
+		c = &callNode{node: n, children: map[*profileNode]*callNode{}}
+		parent.children[n] = c
+	}
+	return c
+}
+
+// add adds a call to the call node
+func (p *Profiler) add(c *callNode, time time.Duration, bytes, objects int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	c.calls++
+	c.time += time
+	c.allocBytes += bytes
+	c.allocObjects += objects
+}
+
+// readAllocs returns the number of allocated bytes and objects
+func (p *Profiler) readAllocs() (int64, int64) {
+	if !p.allocs {
+		return 0, 0
+	}
+	s := [2]metrics.Sample{{Name: "/gc/heap/allocs:bytes"}, {Name: "/gc/heap/allocs:objects"}}
+	metrics.Read(s[:])
+	return int64(s[0].Value.Uint64()), int64(s[1].Value.Uint64())
+}
+
+// profile wraps the given function so that it is profiled
+func (g *FunctionGenerator[V]) profile(f ParserFunc[V], ast parser2.AST, call *parser2.ClosureLiteral) ParserFunc[V] {
+	p := g.profiler
+	n := p.node(ast, call)
+	return func(st Stack[V], cs []V) (V, error) {
+		if st.storage == nil {
+			return f(st, cs)
+		}
+		ps := st.storage.profile
+		if ps == nil {
+			ps = &profileState{current: p.root}
+			st.storage.profile = ps
+		}
+		parent := ps.current
+		ps.current = p.enter(parent, n)
+		childTime, childBytes, childObjects := ps.childTime, ps.childBytes, ps.childObjects
+		ps.childTime, ps.childBytes, ps.childObjects = 0, 0, 0
+
+		bytes, objects := p.readAllocs()
+		start := time.Now()
+		v, err := f(st, cs)
+		elapsed := time.Since(start)
+		b, o := p.readAllocs()
+		bytes, objects = b-bytes, o-objects
+
+		p.add(ps.current, elapsed-ps.childTime, bytes-ps.childBytes, objects-ps.childObjects)
+		ps.childTime = childTime + elapsed
+		ps.childBytes = childBytes + bytes
+		ps.childObjects = childObjects + objects
+		ps.current = parent
+		return v, err
+	}
+}
+
+// walk calls the given function for all nodes of the call tree. The
+// stack contains the call nodes from the root to the visited node.
+func (p *Profiler) walk(f func(stack []*callNode)) {
+	var w func(c *callNode, stack []*callNode)
+	w = func(c *callNode, stack []*callNode) {
+		stack = append(stack, c)
+		f(stack)
+		for _, ch := range sortedChildren(c) {
+			w(ch, stack)
+		}
+	}
+	for _, c := range sortedChildren(p.root) {
+		w(c, nil)
+	}
+}
+
+// sortedChildren returns the children of the call node in a stable order
+func sortedChildren(c *callNode) []*callNode {
+	children := make([]*callNode, 0, len(c.children))
+	for _, ch := range c.children {
+		children = append(children, ch)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].node.id < children[j].node.id })
+	return children
+}
+
+// total returns the time spent in the call node including its children
+func (c *callNode) total() time.Duration {
+	t := c.time
+	for _, ch := range c.children {
+		t += ch.total()
+	}
+	return t
+}
+
+// Nodes returns the profiles of all evaluated nodes, sorted by the
+// time spent in the node itself.
+func (p *Profiler) Nodes() []NodeProfile {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	profiles := map[*profileNode]*NodeProfile{}
+	p.walk(func(stack []*callNode) {
+		c := stack[len(stack)-1]
+		np, ok := profiles[c.node]
+		if !ok {
+			np = &NodeProfile{Name: c.node.name, Line: c.node.line}
+			profiles[c.node] = np
+		}
+		np.Calls += c.calls
+		np.SelfTime += c.time
+		np.AllocBytes += c.allocBytes
+		np.AllocObjects += c.allocObjects
+		// in case of a recursion, the time is already contained in the caller
+		for _, s := range stack[:len(stack)-1] {
+			if s.node == c.node {
+				return
+			}
+		}
+		np.Time += c.total()
+	})
+	var nodes []NodeProfile
+	for _, np := range profiles {
+		nodes = append(nodes, *np)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].SelfTime == nodes[j].SelfTime {
+			return nodes[i].Line < nodes[j].Line
+		}
+		return nodes[i].SelfTime > nodes[j].SelfTime
+	})
+	return nodes
+}
+
+// Lines returns the profiles of all evaluated source lines, sorted by line
+func (p *Profiler) Lines() []LineProfile {
+	lines := map[parser2.Line]*LineProfile{}
+	for _, n := range p.Nodes() {
+		lp, ok := lines[n.Line]
+		if !ok {
+			lp = &LineProfile{Line: n.Line}
+			lines[n.Line] = lp
+		}
+		lp.Time += n.SelfTime
+		lp.AllocBytes += n.AllocBytes
+		lp.AllocObjects += n.AllocObjects
+	}
+	var l []LineProfile
+	for _, lp := range lines {
+		l = append(l, *lp)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Line < l[j].Line })
+	return l
+}
+
+// frameName returns the name of the node used as a frame
+func (n *profileNode) frameName() string {
+	return fmt.Sprintf("%s (line %d)", n.name, n.line)
+}
+
+// WriteFolded writes the folded stacks used to create flame graphs. Each
+// line contains the frames separated by semicolons followed by the time
+// spent in the innermost frame in nanoseconds.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	bw := bufio.NewWriter(w)
+	p.walk(func(stack []*callNode) {
+		c := stack[len(stack)-1]
+		if c.time <= 0 {
+			return
+		}
+		for i, s := range stack {
+			if i > 0 {
+				bw.WriteString(";")
+			}
+			bw.WriteString(s.node.frameName())
+		}
+		fmt.Fprintf(bw, " %d\n", c.time.Nanoseconds())
+	})
+	return bw.Flush()
+}
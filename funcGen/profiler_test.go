@@ -0,0 +1,64 @@
+package funcGen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const profileScript = `let f=x->
+  x*x+1;
+let g=y->f(y)*f(y+1);
+g(a)+f(a)`
+
+func TestProfiler(t *testing.T) {
+	for _, allocs := range []bool{false, true} {
+		p := NewProfiler(allocs)
+		f, err := NewGen().SetProfiler(p).Generate(profileScript, "a")
+		assert.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			r, err := f.Eval(Float(1))
+			assert.NoError(t, err)
+			assert.Equal(t, Float(12), r)
+		}
+
+		calls := map[string]int64{}
+		for _, n := range p.Nodes() {
+			calls[n.Name] += n.Calls
+			assert.True(t, n.Time >= n.SelfTime, n.Name)
+		}
+		assert.EqualValues(t, 2, calls["func g"])
+		assert.EqualValues(t, 6, calls["func f"])
+		assert.EqualValues(t, 6, calls["f()"])
+		assert.EqualValues(t, 2, calls["g()"])
+
+		var lines []int
+		for _, l := range p.Lines() {
+			lines = append(lines, int(l.Line))
+		}
+		assert.Equal(t, []int{1, 2, 3, 4}, lines)
+
+		var folded bytes.Buffer
+		assert.NoError(t, p.WriteFolded(&folded))
+		assert.Contains(t, folded.String(), "g() (line 4);func g (line 3);* (line 3);f() (line 3);func f (line 1);+ (line 2)")
+
+		var pprof bytes.Buffer
+		assert.NoError(t, p.WritePprof(&pprof))
+		r, err := gzip.NewReader(&pprof)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(data, []byte("func g (line 3)")))
+		assert.Equal(t, allocs, bytes.Contains(data, []byte("alloc_space")))
+
+		p.Reset()
+		assert.Empty(t, p.Nodes())
+		folded.Reset()
+		assert.NoError(t, p.WriteFolded(&folded))
+		assert.Equal(t, "", strings.TrimSpace(folded.String()))
+	}
+}
@@ -0,0 +1,37 @@
+package funcGen
+
+import "sync"
+
+// evalState holds the values which belong to a single evaluation. It is
+// shared by all stacks used in an evaluation.
+type evalState struct {
+	mutex  sync.Mutex
+	values map[any]any
+}
+
+// EvalState returns the value stored by the given key in the evaluation s
+// belongs to. If there is no such value, it is created by the given function.
+// This allows functions to hold a state for the duration of an evaluation,
+// like a random source. It is safe to call EvalState concurrently.
+func (s Stack[V]) EvalState(key any, create func() any) any {
+	es := s.state()
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if v, ok := es.values[key]; ok {
+		return v
+	}
+	v := create()
+	es.values[key] = v
+	return v
+}
+
+// state returns the state of the evaluation, which is created if required.
+// Since stacks are created by New concurrently, the state is created
+// atomically.
+func (s Stack[V]) state() *evalState {
+	if es := s.storage.state.Load(); es != nil {
+		return es
+	}
+	s.storage.state.CompareAndSwap(nil, &evalState{values: map[any]any{}})
+	return s.storage.state.Load()
+}
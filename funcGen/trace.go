@@ -0,0 +1,214 @@
+package funcGen
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hneemann/parser2"
+)
+
+// maxArgSummary is the maximum length of the summary of an argument
+const maxArgSummary = 32
+
+// Frame is a frame of a script stack trace
+type Frame struct {
+	// Function is the name of the function, "func" if the function has no name
+	Function string
+	// Args is a short summary of the arguments of the function
+	Args []string
+	// Line is the line of the function at which the error occurred or
+	// the next function was called, zero if not known
+	Line parser2.Line
+	// Go is true if the function is implemented in go
+	Go bool
+}
+
+// TraceError is returned by the functions created by the generator. It
+// contains the error together with the script stack trace of the location
+// the error occurred at. The error message is the message of the wrapped
+// error. The stack trace is obtained by Trace or by formatting the error
+// with "%+v".
+type TraceError struct {
+	// Err is the error which occurred
+	Err error
+	// Frames is the stack trace, the innermost frame comes first and the
+	// last frame is the main function
+	Frames []Frame
+	// callLine is the line at which the innermost frame was called or the
+	// error occurred at, if not known from the error itself
+	callLine parser2.Line
+}
+
+func (e *TraceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TraceError) Unwrap() error {
+	return e.Err
+}
+
+// Trace returns the stack trace formatted like the stack trace of a go panic
+func (e *TraceError) Trace() string {
+	var b strings.Builder
+	for i, f := range e.Frames {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(f.Function)
+		if f.Function != "main" {
+			b.WriteString("(" + strings.Join(f.Args, ", ") + ")")
+		}
+		switch {
+		case f.Go:
+			b.WriteString("\n\t<go function>")
+		case f.Line > 0:
+			fmt.Fprintf(&b, "\n\tline %d", f.Line)
+		default:
+			b.WriteString("\n\t<unknown line>")
+		}
+	}
+	return b.String()
+}
+
+// Format prints the error message. If the verb is "%+v" the stack trace
+// is added.
+func (e *TraceError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%s\n\nscript stack trace:\n%s", e.Err.Error(), e.Trace())
+		return
+	}
+	fmt.Fprint(s, e.Err.Error())
+}
+
+// errorLine returns the innermost line found in the error chain above the
+// stack trace, which is the line the error occurred at in the current frame.
+func errorLine(err error) (parser2.Line, *TraceError) {
+	var line parser2.Line
+	for err != nil {
+		if te, ok := err.(*TraceError); ok {
+			if te.callLine > 0 {
+				line = te.callLine
+			}
+			return line, te
+		}
+		if l, ok := err.(interface{ GetLine() parser2.Line }); ok && l.GetLine() > 0 {
+			line = l.GetLine()
+		}
+		err = errors.Unwrap(err)
+	}
+	return line, nil
+}
+
+// atLine records the line an error occurred at or the function returning
+// the error was called at, if not already done in the current frame
+func atLine(err error, line parser2.Line) error {
+	if line == 0 {
+		return err
+	}
+	_, te := errorLine(err)
+	if te == nil {
+		return &TraceError{Err: err, callLine: line}
+	}
+	if te.callLine == 0 {
+		te.callLine = line
+	}
+	return err
+}
+
+// addFrame adds a frame to the stack trace of the error. If the error
+// does not contain a stack trace, it is wrapped by a TraceError.
+func addFrame(err error, f Frame) error {
+	line, te := errorLine(err)
+	if !f.Go {
+		f.Line = line
+	}
+	if te == nil {
+		return &TraceError{Err: err, Frames: []Frame{f}}
+	}
+	te.Frames = append(te.Frames, f)
+	te.callLine = 0
+	return err
+}
+
+// Summarizer can be implemented by values to provide a short description
+// used in stack traces. It is useful if the string representation of a value
+// is expensive to create, like the one of a lazy evaluated list.
+type Summarizer interface {
+	Summary() string
+}
+
+// argSummary returns a short description of a value
+func argSummary[V any](v V) string {
+	var s string
+	if su, ok := any(v).(Summarizer); ok {
+		s = su.Summary()
+	} else {
+		s = fmt.Sprint(v)
+	}
+	if r := []rune(s); len(r) > maxArgSummary {
+		s = string(r[:maxArgSummary-3]) + "..."
+	}
+	return s
+}
+
+// traced wraps the function created from a closure literal, so that a
+// frame is added to the stack trace if an error occurs
+func traced[V any](f ParserFunc[V], a *parser2.ClosureLiteral, name string) ParserFunc[V] {
+	if name == "" {
+		name = "func"
+	}
+	return func(st Stack[V], cs []V) (V, error) {
+		v, err := f(st, cs)
+		if err != nil {
+			args := make([]string, st.Size())
+			for i := range args {
+				args[i] = argSummary(st.Get(i))
+				if i < len(a.Names) {
+					args[i] = a.Names[i] + "=" + args[i]
+				}
+			}
+			err = addFrame(err, Frame{Function: name, Args: args})
+		}
+		return v, err
+	}
+}
+
+// goFrame adds the frame of a go function to the stack trace of the error
+func goFrame[V any](err error, st Stack[V], name string, line parser2.Line) error {
+	args := make([]string, st.Size())
+	for i := range args {
+		args[i] = argSummary(st.Get(i))
+	}
+	return atLine(addFrame(err, Frame{Function: name, Args: args, Go: true}), line)
+}
+
+// mainFrame adds the frame of the main function to the stack trace. The
+// returned error is always a *TraceError, so that the stack trace is
+// available to the caller without unwrapping.
+func mainFrame(err error) error {
+	err = addFrame(err, Frame{Function: "main"})
+	if te, ok := err.(*TraceError); ok {
+		return te
+	}
+	var te *TraceError
+	errors.As(err, &te)
+	return &TraceError{Err: err, Frames: te.Frames}
+}
+
+// ErrorLine returns the line the error occurred at, zero if not known.
+// If the error contains a stack trace, the line of the innermost script
+// function is returned.
+func ErrorLine(err error) parser2.Line {
+	line, te := errorLine(err)
+	if line > 0 || te == nil {
+		return line
+	}
+	for _, f := range te.Frames {
+		if !f.Go && f.Line > 0 {
+			return f.Line
+		}
+	}
+	line, _ = errorLine(te.Err)
+	return line
+}
@@ -0,0 +1,90 @@
+package funcGen
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceError(t *testing.T) {
+	cause := errors.New("fail called")
+	tests := []struct {
+		name   string
+		exp    string
+		frames []Frame
+	}{
+		{name: "go", exp: "let f=x->\n  fail(x);\nlet g=y->\n  f(y*2)+1;\ng(a)", frames: []Frame{
+			{Function: "fail", Args: []string{"2.000000"}, Go: true},
+			{Function: "func", Args: []string{"x=2.000000"}, Line: 2},
+			{Function: "func", Args: []string{"y=1.000000"}, Line: 4},
+			{Function: "main", Line: 5},
+		}},
+		{name: "named", exp: "func f(x)\n  fail(x);\n\n1+f(a)", frames: []Frame{
+			{Function: "fail", Args: []string{"1.000000"}, Go: true},
+			{Function: "f", Args: []string{"x=1.000000"}, Line: 2},
+			{Function: "main", Line: 4},
+		}},
+		{name: "op", exp: "let f=x->\n  x+(y->y);\nf(a)", frames: []Frame{
+			{Function: "func", Args: []string{"x=1.000000"}, Line: 2},
+			{Function: "main", Line: 3},
+		}},
+		{name: "main", exp: "1+\nfail(a)", frames: []Frame{
+			{Function: "fail", Args: []string{"1.000000"}, Go: true},
+			{Function: "main", Line: 2},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewGen().
+				AddGoFunction("fail", 1, func(a ...Value) (Value, error) {
+					return nil, cause
+				}).
+				Generate(test.exp, "a")
+			assert.NoError(t, err)
+			_, err = f.Eval(Float(1))
+			if te, ok := err.(*TraceError); assert.True(t, ok, err) {
+				assert.Equal(t, test.frames, te.Frames)
+			}
+			assert.Equal(t, test.name != "op", errors.Is(err, cause))
+		})
+	}
+}
+
+func TestTraceErrorFormat(t *testing.T) {
+	cause := errors.New("cause")
+	err := &TraceError{Err: cause, Frames: []Frame{
+		{Function: "fail", Args: []string{"1"}, Go: true},
+		{Function: "f", Args: []string{"x=1", "y=2"}, Line: 2},
+		{Function: "func", Args: []string{}},
+		{Function: "main", Line: 4},
+	}}
+	assert.Equal(t, "cause", err.Error())
+	assert.Equal(t, "cause", fmt.Sprint(err))
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "cause\n\nscript stack trace:\n"+
+		"fail(1)\n\t<go function>\n"+
+		"f(x=1, y=2)\n\tline 2\n"+
+		"func()\n\t<unknown line>\n"+
+		"main\n\tline 4", fmt.Sprintf("%+v", err))
+}
+
+func TestArgSummary(t *testing.T) {
+	assert.Equal(t, "1.000000", argSummary[Value](Float(1)))
+	assert.Equal(t, "<nil>", argSummary[Value](nil))
+	assert.Equal(t, "123456789012345678901234567890", argSummary("123456789012345678901234567890"))
+	assert.Equal(t, "12345678901234567890123456789...", argSummary("1234567890123456789012345678901234567890"))
+	assert.Equal(t, "summary", argSummary(summarizer{}))
+	assert.EqualValues(t, 3, atLine(errors.New("test"), 3).(*TraceError).callLine)
+}
+
+type summarizer struct{}
+
+func (summarizer) Summary() string {
+	return "summary"
+}
+
+func (summarizer) String() string {
+	return "string"
+}
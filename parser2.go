@@ -106,6 +106,11 @@ func (e errorWithLine) Unwrap() error {
 	return e.cause
 }
 
+// GetLine returns the line the error occurred in, zero if not known
+func (e errorWithLine) GetLine() Line {
+	return e.line
+}
+
 func (l Line) Errorf(m string, a ...any) error {
 	return errorWithLine{
 		message: fmt.Sprintf(m, a...),
@@ -435,6 +440,8 @@ type ClosureLiteral struct {
 	Name  string
 	Names []string
 	Func  AST
+	// Memo is true if the closure was declared using 'memo func'
+	Memo bool
 	Line
 }
 
@@ -864,49 +871,65 @@ func (p *Parser[V]) parseLet(tokenizer *Tokenizer, constants Constants[V]) (AST,
 			}, nil
 		} else if t.image == "func" {
 			tokenizer.Next()
-			t = tokenizer.Next()
-			if t.typ != tIdent {
-				return nil, t.Errorf("no identifier followed by func")
-			}
-			name := t.image
-			if _, ok := constants.GetConst(name); ok {
-				return nil, t.Errorf("there is already a constant named '%s'", name)
-			}
-			line := t.GetLine()
-			if t := tokenizer.Next(); t.typ != tOpen {
-				return nil, unexpected("(", t)
-			}
-			names, err := p.parseIdentList(tokenizer)
-			if err != nil {
-				return nil, err
-			}
-			exp, err := p.parseLet(tokenizer, constants)
-			if err != nil {
-				return nil, err
-			}
-			if t := tokenizer.Next(); t.typ != tSemicolon || t.image != ";" {
-				return nil, unexpected(";", t)
-			}
-			inner, err := p.parseLet(tokenizer, constants)
-			if err != nil {
-				return nil, err
+			return p.parseFunc(tokenizer, constants, false)
+		} else if t.image == "memo" {
+			// memo is only a keyword if followed by func, so it
+			// can still be used as an identifier
+			if n := tokenizer.PeekPeek(); n.typ == tIdent && n.image == "func" {
+				tokenizer.Next()
+				tokenizer.Next()
+				return p.parseFunc(tokenizer, constants, true)
 			}
-			return &Let{
-				Name: name,
-				Value: &ClosureLiteral{
-					Name:  name,
-					Names: names,
-					Func:  exp,
-					Line:  line,
-				},
-				Inner: inner,
-				Line:  line,
-			}, nil
 		}
 	}
 	return p.parseExpression(tokenizer, constants)
 }
 
+// parseFunc parses a function declaration like 'func name(a,b) exp;'.
+// The keyword 'func' is already consumed. If memo is true, the
+// created closure is marked to be memoized.
+func (p *Parser[V]) parseFunc(tokenizer *Tokenizer, constants Constants[V], memo bool) (AST, error) {
+	t := tokenizer.Next()
+	if t.typ != tIdent {
+		return nil, t.Errorf("no identifier followed by func")
+	}
+	name := t.image
+	if _, ok := constants.GetConst(name); ok {
+		return nil, t.Errorf("there is already a constant named '%s'", name)
+	}
+	line := t.GetLine()
+	if t := tokenizer.Next(); t.typ != tOpen {
+		return nil, unexpected("(", t)
+	}
+	names, err := p.parseIdentList(tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := p.parseLet(tokenizer, constants)
+	if err != nil {
+		return nil, err
+	}
+	if t := tokenizer.Next(); t.typ != tSemicolon || t.image != ";" {
+		return nil, unexpected(";", t)
+	}
+	inner, err := p.parseLet(tokenizer, constants)
+	if err != nil {
+		return nil, err
+	}
+	return &Let{
+		Name: name,
+		Value: &ClosureLiteral{
+			Name:  name,
+			Names: names,
+			Func:  exp,
+			Memo:  memo,
+			Line:  line,
+		},
+		Inner: inner,
+		Line:  line,
+	}, nil
+}
+
 func (p *Parser[V]) parseExpression(tokenizer *Tokenizer, constants Constants[V]) (AST, error) {
 	return p.parseOp(tokenizer, 0, constants)
 }
@@ -0,0 +1,114 @@
+package value
+
+import (
+	"errors"
+
+	"github.com/hneemann/parser2"
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/hneemann/parser2/listMap"
+)
+
+// ScriptError is the error created by the throw function. It is obtained
+// from the error returned by an evaluation using errors.As. Other errors
+// are converted to a ScriptError by ToScriptError. The catch closure of a
+// try-catch expression receives the error as a map created by ToMap.
+type ScriptError struct {
+	// Message is the message of the error
+	Message string
+	// Line is the line the error occurred at, zero if not known
+	Line parser2.Line
+	// Value is the thrown value, NIL if the error was not created by throw
+	Value Value
+	// Cause is the error which caused this error, nil if there is none
+	Cause *ScriptError
+	// err is the converted error, nil if the error was created by throw
+	err error
+}
+
+func (e *ScriptError) Error() string {
+	return e.Message
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.err
+}
+
+// GetLine returns the line the error occurred at, zero if not known
+func (e *ScriptError) GetLine() parser2.Line {
+	return e.Line
+}
+
+// ToMap returns the map passed to the catch closure. It contains the keys
+// message, line, value and cause. The cause is the map of the cause or NIL.
+func (e *ScriptError) ToMap() Map {
+	var cause Value = NIL
+	if e.Cause != nil {
+		cause = e.Cause.ToMap()
+	}
+	return NewMap(listMap.New[Value](4).
+		Append("message", String(e.Message)).
+		Append("line", Int(e.Line)).
+		Append("value", e.Value).
+		Append("cause", cause))
+}
+
+// newThrowError creates the error thrown by the throw function. If the
+// thrown value is a map containing a message, e.g. an error map passed to
+// a catch closure, this message is used.
+func newThrowError(st funcGen.Stack[Value], v Value, line parser2.Line) error {
+	message, ok := throwMessage(v)
+	if !ok {
+		var err error
+		message, err = v.ToString(st)
+		if err != nil {
+			return err
+		}
+	}
+	return &ScriptError{Message: message, Line: line, Value: v}
+}
+
+// throwMessage returns the message of a thrown string or map
+func throwMessage(v Value) (string, bool) {
+	if m, ok := v.(Map); ok {
+		v, ok = m.Get("message")
+		if !ok {
+			return "", false
+		}
+	}
+	if s, ok := v.(String); ok {
+		return string(s), true
+	}
+	return "", false
+}
+
+// ToScriptError converts an error to a ScriptError. If the error was
+// created by throw, the thrown error is returned. Otherwise, the error chain
+// is converted to a chain of ScriptErrors.
+func ToScriptError(err error) *ScriptError {
+	var se *ScriptError
+	if errors.As(err, &se) {
+		if se.Line == 0 {
+			se.Line = funcGen.ErrorLine(err)
+		}
+		return se
+	}
+	line := funcGen.ErrorLine(err)
+	err = skipTrace(err)
+	se = &ScriptError{Message: err.Error(), Line: line, Value: NIL, err: err}
+	if cause := skipTrace(errors.Unwrap(err)); cause != nil {
+		se.Cause = ToScriptError(cause)
+	}
+	return se
+}
+
+// skipTrace removes the stack traces which do not contribute to
+// the error message
+func skipTrace(err error) error {
+	for {
+		if te, ok := err.(*funcGen.TraceError); ok {
+			err = te.Err
+		} else {
+			return err
+		}
+	}
+}
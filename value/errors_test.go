@@ -66,16 +66,15 @@ func TestErrors(t *testing.T) {
 		{exp: "func mul(a,b) a*b; mul(2)", err: "wrong number of arguments at call of \"mul\", required 2, found 1 in line 1"},
 		{exp: "let m={a:(x,y)->x*y};m.a(2)", err: "wrong number of arguments at call of \"a\", required 2, found 1"},
 		{exp: "[].size(1)", err: ", required 0, found 1"},
+		{exp: "let a=1;\n[].first()", err: "error in constant pre evaluation of method: first in line 2"},
+		{exp: "let a=1;\n\"abc\".foo()", err: "error accessing method foo in line 2"},
 	}
 
 	fg := New().AddStaticFunction("error", toLargeErrorFunc(100))
 	for _, tt := range tests {
 		test := tt
 		t.Run(test.exp, func(t *testing.T) {
-			f, err := fg.Generate(test.exp)
-			if err == nil {
-				_, err = f(funcGen.NewEmptyStack[Value]())
-			}
+			err := evalError(fg, test.exp)
 			if err == nil {
 				t.Errorf("expected an error containing '%v'", test.err)
 			} else {
@@ -84,3 +83,33 @@ func TestErrors(t *testing.T) {
 		})
 	}
 }
+
+func evalError(fg *funcGen.FunctionGenerator[Value], exp string) error {
+	f, err := fg.Generate(exp)
+	if err == nil {
+		_, err = f(funcGen.NewEmptyStack[Value]())
+	}
+	return err
+}
+
+func TestScriptError(t *testing.T) {
+	err := evalError(New().FunctionGenerator, "let f=x->\n  throw({code:x});\nf(3)")
+	var se *ScriptError
+	if assert.True(t, errors.As(err, &se), err) {
+		assert.Equal(t, "{code:3}", se.Message)
+		assert.EqualValues(t, 2, se.Line)
+		code, _ := se.Value.(Map).Get("code")
+		assert.Equal(t, Int(3), code)
+		assert.Nil(t, se.Cause)
+	}
+
+	err = evalError(New().FunctionGenerator, "let f=m->\n  sqrt(m.b);\nf({a:1})")
+	assert.False(t, errors.As(err, &se))
+	se = ToScriptError(err)
+	assert.Equal(t, err.Error(), se.Message)
+	assert.EqualValues(t, 2, se.Line)
+	assert.Equal(t, NIL, se.Value)
+	if assert.NotNil(t, se.Cause) {
+		assert.True(t, strings.Contains(se.Cause.Message, "key 'b' not found"), se.Cause.Message)
+	}
+}
@@ -0,0 +1,99 @@
+package value
+
+import (
+	"github.com/hneemann/parser2/funcGen"
+)
+
+// EnableJit enables the jit compiler and sets up the conversions between
+// the values and the go types used by the compiled functions.
+// Ints are represented as int, floats as float64, maps as map[string]any
+// and lists as []any. All other values are passed through the compiled
+// code unchanged.
+func (fg *FunctionGenerator) EnableJit() *FunctionGenerator {
+	fg.SetJit()
+	jit := fg.GetJit()
+	jit.ValueToUnderlying = toUnderlying
+	jit.UnderlyingToValue = fromUnderlying
+	jit.TypeToString = underlyingType
+	return fg
+}
+
+func toUnderlying(v Value) any {
+	switch t := v.(type) {
+	case Bool:
+		return bool(t)
+	case Int:
+		return int(t)
+	case Float:
+		return float64(t)
+	case String:
+		return string(t)
+	case Map:
+		m := make(map[string]any, t.Size())
+		t.Iter(func(key string, v Value) bool {
+			m[key] = toUnderlying(v)
+			return true
+		})
+		return m
+	case *List:
+		items, err := t.ToSlice(funcGen.NewEmptyStack[Value]())
+		if err != nil {
+			return v
+		}
+		l := make([]any, len(items))
+		for i, item := range items {
+			l[i] = toUnderlying(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func fromUnderlying(v any) Value {
+	switch t := v.(type) {
+	case Value:
+		return t
+	case int:
+		return Int(t)
+	case float64:
+		return Float(t)
+	case bool:
+		return Bool(t)
+	case string:
+		return String(t)
+	case map[string]any:
+		m := make(RealMap, len(t))
+		for k, v := range t {
+			m[k] = fromUnderlying(v)
+		}
+		return NewMap(m)
+	case []any:
+		l := make([]Value, len(t))
+		for i, item := range t {
+			l[i] = fromUnderlying(item)
+		}
+		return NewList(l...)
+	default:
+		return NIL
+	}
+}
+
+func underlyingType(v Value) string {
+	switch v.(type) {
+	case String:
+		return "string"
+	case Int:
+		return "int"
+	case Float:
+		return "float64"
+	case Bool:
+		return "bool"
+	case Map:
+		return "map[string]any"
+	case *List:
+		return "[]any"
+	default:
+		return "any"
+	}
+}
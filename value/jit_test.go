@@ -1,7 +1,13 @@
 package value
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hneemann/parser2/funcGen"
@@ -26,7 +32,7 @@ func BenchmarkJit(b *testing.B) {
 	for _, input := range inputs {
 		for _, iter := range iterations {
 			input.input = strings.Replace(input.input, "%iterations%", iter, 1)
-			b.Run(input.name+":NOJIT:"+iter+":JIT_CONSTANT=OFF", func(b *testing.B) {
+			b.Run(input.name+":NOJIT:"+iter+":OFF", func(b *testing.B) {
 				parser := New()
 				parser.GetParser().AllowComments()
 				f, err := parser.Generate(input.input)
@@ -37,10 +43,11 @@ func BenchmarkJit(b *testing.B) {
 		}
 		for _, iter := range iterations {
 			input.input = strings.Replace(input.input, "%iterations%", iter, 1)
-			b.Run(input.name+":JIT:"+iter+":JIT_CONSTANT=1_000", func(b *testing.B) {
+			b.Run(input.name+":JIT:"+iter+":threshold=1_000", func(b *testing.B) {
 				parser := New()
 				parser.GetParser().AllowComments()
-				parser.SetJit()
+				parser.EnableJit()
+				parser.SetJitThreshold(1_000)
 				defer parser.GetJit().Cancel()
 				f, err := parser.Generate(input.input)
 				assert.NoError(b, err)
@@ -50,11 +57,11 @@ func BenchmarkJit(b *testing.B) {
 		}
 		for _, iter := range iterations {
 			input.input = strings.Replace(input.input, "%iterations%", iter, 1)
-			b.Run(input.name+":JIT:"+iter+":JIT_CONSTANT=10_000", func(b *testing.B) {
-				funcGen.JIT_CONSTANT = 10_000
+			b.Run(input.name+":JIT:"+iter+":threshold=10_000", func(b *testing.B) {
 				parser := New()
 				parser.GetParser().AllowComments()
-				parser.SetJit()
+				parser.EnableJit()
+				parser.SetJitThreshold(10_000)
 				defer parser.GetJit().Cancel()
 				f, err := parser.Generate(input.input)
 				assert.NoError(b, err)
@@ -64,11 +71,11 @@ func BenchmarkJit(b *testing.B) {
 		}
 		for _, iter := range iterations {
 			input.input = strings.Replace(input.input, "%iterations%", iter, 1)
-			b.Run(input.name+":JIT:"+iter+":JIT_CONSTANT=100_000", func(b *testing.B) {
-				funcGen.JIT_CONSTANT = 100_000
+			b.Run(input.name+":JIT:"+iter+":threshold=100_000", func(b *testing.B) {
 				parser := New()
 				parser.GetParser().AllowComments()
-				parser.SetJit()
+				parser.EnableJit()
+				parser.SetJitThreshold(100_000)
 				defer parser.GetJit().Cancel()
 				f, err := parser.Generate(input.input)
 				assert.NoError(b, err)
@@ -78,3 +85,452 @@ func BenchmarkJit(b *testing.B) {
 		}
 	}
 }
+
+func skipIfNoJit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("jit tests are slow")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("go plugins are not supported on windows")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+}
+
+func newJitFG() *FunctionGenerator {
+	fg := New()
+	fg.GetParser().AllowComments()
+	fg.EnableJit()
+	fg.GetJit().Synchronous = true
+	fg.SetJitThreshold(10)
+	return fg
+}
+
+func TestJitBenchmarkScripts(t *testing.T) {
+	skipIfNoJit(t)
+	files, err := filepath.Glob("../benchmark/*/*100k.neemann")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			assert.NoError(t, err)
+
+			fg := New()
+			fg.GetParser().AllowComments()
+			exp, err := eval(fg.FunctionGenerator, string(src))
+			assert.NoError(t, err)
+
+			jit := newJitFG()
+			jit.SetJitThreshold(funcGen.DefaultJitThreshold)
+			defer jit.GetJit().Cancel()
+			res, err := eval(jit.FunctionGenerator, string(src))
+			assert.NoError(t, err)
+
+			assertSameResult(t, exp, res)
+		})
+	}
+}
+
+func TestJitCodegen(t *testing.T) {
+	skipIfNoJit(t)
+	tests := []string{
+		"list(100).map(i->if i<50 then i*2 else -i).sum()",
+		"list(100).map(i->switch i%3 case 0:\"a\" case 1:\"b\" default \"c\").reduce((a,b)->a+b)",
+		"list(100).map(i->sqrt(i*i)).sum()",
+		"list(100).map(i->\"n\"+i.string()).map(s->s.len()).sum()",
+		"list(100).map(i->[i,i+1,i+2][1]).sum()",
+		"list(100).map(i->[i,i*2].map(e->e+1).sum()).sum()",
+		"list(100).map(i->{a:i,b:\"x\"}).map(m->m.a+m.b.len()).sum()",
+		"list(100).map(i->let f=x->x*i; f(2)+f(3)).sum()",
+		"list(100).map(i->let s=\"ab\\\"c\"; s+i).map(s->s.len()).sum()",
+		"list(100).filter(i->i>10 & (i<20 | i=50)).size()",
+		"list(100).map(i->{f:x->x+i}).map(m->m.f(1)).sum()",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			exp, err := eval(New().FunctionGenerator, test)
+			assert.NoError(t, err)
+
+			jit := newJitFG()
+			defer jit.GetJit().Cancel()
+			res, err := eval(jit.FunctionGenerator, test)
+			assert.NoError(t, err)
+
+			assertSameResult(t, exp, res)
+		})
+	}
+}
+
+// assertSameResult compares the string representations because the
+// results may be NaN.
+func assertSameResult(t *testing.T, exp, res Value) {
+	st := funcGen.NewEmptyStack[Value]()
+	expStr, err := exp.ToString(st)
+	assert.NoError(t, err)
+	resStr, err := res.ToString(st)
+	assert.NoError(t, err)
+	assert.Equal(t, expStr, resStr)
+}
+
+func TestJitCache(t *testing.T) {
+	skipIfNoJit(t)
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		jit := newJitFG()
+		jit.GetJit().CacheDir = dir
+		res, err := eval(jit.FunctionGenerator, "let n=100; list(n).map(i->i*2).sum()")
+		jit.GetJit().Cancel()
+		assert.NoError(t, err)
+		assertSameResult(t, Int(9900), res)
+
+		plugins, err := filepath.Glob(filepath.Join(dir, "*.so"))
+		assert.NoError(t, err)
+		assert.Len(t, plugins, 1)
+	}
+
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	jit.GetJit().CacheDir = dir
+	assert.NoError(t, jit.GetJit().ClearCache())
+	plugins, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	assert.NoError(t, err)
+	assert.Empty(t, plugins)
+}
+
+func TestJitCompileBatch(t *testing.T) {
+	skipIfNoJit(t)
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	dir := t.TempDir()
+	jit.GetJit().CacheDir = dir
+
+	l, err := eval(jit.FunctionGenerator, "[x->x*2, (a,b)->a+b, s->s+\"x\", x->try x catch 1]")
+	assert.NoError(t, err)
+	items, err := l.(*List).ToSlice(funcGen.NewEmptyStack[Value]())
+	assert.NoError(t, err)
+
+	types := [][]string{{"int"}, {"int", "float64"}, {"string"}, {"int"}}
+	var funcs []*funcGen.Function[Value]
+	for i, item := range items {
+		f, ok := item.ToClosure()
+		assert.True(t, ok)
+		var params []funcGen.MetaDataParameter
+		for n, typ := range types[i] {
+			params = append(params, funcGen.MetaDataParameter{Name: f.ArgumentNames[n], Type: typ})
+		}
+		f.MetaData = &funcGen.MetaData{Parameters: params}
+		funcs = append(funcs, &f)
+	}
+
+	assert.NoError(t, jit.GetJit().CompileBatch(funcs))
+
+	plugins, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	assert.NoError(t, err)
+	assert.Len(t, plugins, 1)
+
+	res, err := funcs[0].JitFunctions()[0](3)
+	assert.NoError(t, err)
+	assert.Equal(t, Int(6), res)
+	res, err = funcs[1].JitFunctions()[0](3, 4.5)
+	assert.NoError(t, err)
+	assert.Equal(t, Float(7.5), res)
+	res, err = funcs[2].JitFunctions()[0]("a")
+	assert.NoError(t, err)
+	assert.Equal(t, String("ax"), res)
+	// try-catch is not supported by the jit
+	assert.Nil(t, funcs[3].JitFunctions())
+}
+
+func TestJitConcurrentEval(t *testing.T) {
+	skipIfNoJit(t)
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	testConcurrentEval(t, jit)
+}
+
+func testConcurrentEval(t *testing.T, fg *FunctionGenerator) {
+	f, err := fg.Generate("list(200).map(i->i*2).filter(i->i>=0).sum()")
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				res, err := f.Eval()
+				assert.NoError(t, err)
+				assertSameResult(t, Int(39800), res)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestJitDeoptimization(t *testing.T) {
+	skipIfNoJit(t)
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	c, err := eval(jit.FunctionGenerator, "x->x*2+x/4")
+	assert.NoError(t, err)
+	f, ok := c.ToClosure()
+	assert.True(t, ok)
+
+	st := funcGen.NewEmptyStack[Value]()
+	for i := 0; i < 50; i++ {
+		res, err := f.Eval(st, Int(i))
+		assert.NoError(t, err)
+		assert.Equal(t, Float(float64(i*2)+float64(i)/4), res)
+	}
+	assert.Len(t, f.JitFunctions(), 1)
+
+	// the compiled variant rejects floats and a second variant is compiled
+	for i := 0; i < 50; i++ {
+		res, err := f.Eval(st, Float(float64(i)+0.5))
+		assert.NoError(t, err)
+		x := float64(i) + 0.5
+		assert.Equal(t, Float(x*2+x/4), res)
+	}
+	assert.Len(t, f.JitFunctions(), 2)
+
+	// both variants stay in use
+	res, err := f.Eval(st, Int(8))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(18), res)
+	// the interpreter creates the error
+	_, err = f.Eval(st, String("a"))
+	assert.ErrorContains(t, err, "'mul' not allowed on String, Int")
+}
+
+func TestJitBailOut(t *testing.T) {
+	skipIfNoJit(t)
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	count := 0
+	jit.AddStaticFunction("count", funcGen.Function[Value]{
+		Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
+			count++
+			return st.Get(0), nil
+		},
+		Args: 1,
+	})
+	jit.AddStaticFunction("check", toLargeErrorFunc(30))
+	c, err := eval(jit.FunctionGenerator, "x->check(count(x))")
+	assert.NoError(t, err)
+	f, ok := c.ToClosure()
+	assert.True(t, ok)
+
+	st := funcGen.NewEmptyStack[Value]()
+	for i := 0; i < 20; i++ {
+		res, err := f.Eval(st, Int(i))
+		assert.NoError(t, err)
+		assert.Equal(t, Int(i), res)
+	}
+	assert.Len(t, f.JitFunctions(), 1)
+	assert.Equal(t, 20, count)
+
+	// the first failing call runs the compiled code and the interpreter,
+	// after that the compiled variant is no longer used
+	for i := 0; i < 3; i++ {
+		_, err = f.Eval(st, Int(40))
+		assert.ErrorContains(t, err, "toLarge")
+	}
+	assert.Equal(t, 24, count)
+
+	res, err := f.Eval(st, Int(5))
+	assert.NoError(t, err)
+	assert.Equal(t, Int(5), res)
+	assert.Equal(t, 25, count)
+
+	fs := jit.GetJit().Stats()
+	assert.EqualValues(t, 1, fs.BailedOut)
+	if assert.Len(t, fs.Functions, 1) {
+		assert.EqualValues(t, 1, fs.Functions[0].BailOuts)
+		assert.EqualValues(t, 9, fs.Functions[0].CallsAfter)
+	}
+}
+
+func TestJitRandomSeed(t *testing.T) {
+	skipIfNoJit(t)
+	const exp = "list(20).map(i->random(1000)).eval()"
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	jf, err := jit.SetRandomSeed(42).Generate(exp)
+	assert.NoError(t, err)
+	f, err := New().SetRandomSeed(42).Generate(exp)
+	assert.NoError(t, err)
+
+	// the random source belongs to the evaluation, so the compiled code
+	// has to continue the sequence of the interpreter
+	want := evalList(t, f)
+	assert.Equal(t, want, evalList(t, jf))
+	assert.Equal(t, want, evalList(t, jf))
+	assert.Positive(t, jit.GetJit().Stats().Compiled)
+}
+
+func evalList(t *testing.T, f funcGen.Func[Value]) []Value {
+	res, err := f.Eval()
+	assert.NoError(t, err)
+	l, err := res.(*List).ToSlice(funcGen.NewEmptyStack[Value]())
+	assert.NoError(t, err)
+	return l
+}
+
+func TestJitIntSemantics(t *testing.T) {
+	skipIfNoJit(t)
+	tests := []string{
+		"list(100).map(i->i*3-1).sum()",
+		"list(100).map(i->i%7).sum()",
+		"list(100).map(i->i/2).sum()",
+		"list(100).map(i->i*0.5+1).sum()",
+		"list(100).map(i->if i=50 then 1 else 0).sum()",
+		"list(100).map(i->if i=50.0 then 1 else 0).sum()",
+		"list(100).map(i->-i).sum()",
+		"list(100).map(i->[1,2,3][i%3]).sum()",
+		"list(100).map(i->\"n\"+i).map(s->s.len()).sum()",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			exp, err := eval(New().FunctionGenerator, test)
+			assert.NoError(t, err)
+
+			jit := newJitFG()
+			defer jit.GetJit().Cancel()
+			res, err := eval(jit.FunctionGenerator, test)
+			assert.NoError(t, err)
+			assert.Equal(t, exp, res)
+		})
+	}
+}
+
+func TestJitStats(t *testing.T) {
+	skipIfNoJit(t)
+
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	var events []funcGen.JitEvent
+	jit.GetJit().OnEvent = func(e funcGen.JitEvent) {
+		events = append(events, e)
+	}
+	res, err := eval(jit.FunctionGenerator, "let n=100; list(n).map(i->i*2).map(i->try i catch 0).sum()")
+	assert.NoError(t, err)
+	assert.Equal(t, Int(9900), res)
+
+	st := jit.GetJit().Stats()
+	assert.EqualValues(t, 2, st.Queued)
+	assert.EqualValues(t, 1, st.Compiled)
+	assert.EqualValues(t, 1, st.Failed)
+	assert.EqualValues(t, 0, st.BailedOut)
+	assert.True(t, st.CompileTime > 0)
+	assert.Len(t, st.Functions, 1)
+	fs := st.Functions[0]
+	assert.Equal(t, "int", fs.Signature)
+	assert.EqualValues(t, 11, fs.CallsBefore)
+	assert.EqualValues(t, 89, fs.CallsAfter)
+	assert.EqualValues(t, 0, fs.Deopts)
+
+	var kinds []funcGen.JitEventKind
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Contains(t, kinds, funcGen.JitCompiled)
+	assert.Contains(t, kinds, funcGen.JitSkipped)
+}
+
+func TestJitTrickyIdentifiers(t *testing.T) {
+	skipIfNoJit(t)
+	tests := []string{
+		"let n=100; list(n).map('my var'->'my var'*2).sum()",
+		"let n=100; list(n).map(i->let 'a b'=i; let a_b=1; 'a b'+a_b).sum()",
+		`let n=100; list(n).map(i->let 'x"y'="\"); panic(\""+i; 'x"y'.len()+i).sum()`,
+		"let n=100; list(n).map(i->{'a b':i}).map(m->m.'a b').sum()",
+		"let n=100; list(n).map(i->\"`\\\\\\n\"+i).map(s->s.len()).sum()",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			exp, err := eval(New().FunctionGenerator, test)
+			assert.NoError(t, err)
+
+			jit := newJitFG()
+			defer jit.GetJit().Cancel()
+			res, err := eval(jit.FunctionGenerator, test)
+			assert.NoError(t, err)
+			assertSameResult(t, exp, res)
+
+			st := jit.GetJit().Stats()
+			assert.EqualValues(t, 0, st.Failed)
+			assert.True(t, st.Compiled > 0)
+		})
+	}
+}
+
+func TestJitRecursion(t *testing.T) {
+	skipIfNoJit(t)
+	tests := []struct {
+		name string
+		exp  string
+	}{
+		{name: "fib", exp: "func fib(n) if n<2 then n else fib(n-1)+fib(n-2); list(30).map(i->fib(i%15)).sum()"},
+		{name: "fib", exp: "func fib(n) if n<2 then n else fib(n-1)+fib(n-2); list(30).map(i->fib(i%15+0.5)).sum()"},
+		{name: "fact", exp: "let fact = n->if n<=1 then 1 else n*fact(n-1); list(30).map(i->fact(i%10)).sum()"},
+		{name: "grow", exp: "func grow(x) if x>100 then x else grow(x*1.5); list(30).map(i->grow(i+1)).sum()"},
+		{name: "sum", exp: "func sum(l) if l.size()=0 then 0 else l[0]+sum(l.skip(1)); list(30).map(i->sum(list(i%5))).sum()"},
+		{name: "f", exp: "func f(n) if n<1 then 0 else list(n).map(i->f(i)).sum()+1; list(30).map(i->f(i%5)).sum()"},
+		{name: "f", exp: "func f(n) if n<1 then 0 else f(n-1)+1; list(30).map(i->list(3).map(f).sum()).sum()"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			exp, err := eval(New().FunctionGenerator, test.exp)
+			assert.NoError(t, err)
+
+			jit := newJitFG()
+			defer jit.GetJit().Cancel()
+			var sources []string
+			jit.GetJit().OnEvent = func(e funcGen.JitEvent) {
+				if e.Kind == funcGen.JitSource {
+					sources = append(sources, e.Source)
+				}
+			}
+			res, err := eval(jit.FunctionGenerator, test.exp)
+			assert.NoError(t, err)
+			assertSameResult(t, exp, res)
+
+			st := jit.GetJit().Stats()
+			var names []string
+			for _, f := range st.Functions {
+				names = append(names, f.Name)
+			}
+			assert.Contains(t, names, test.name)
+			// the recursive calls stay inside the compiled code
+			assert.Contains(t, strings.Join(sources, "\n"), "jitJIT_"+test.name+"(")
+		})
+	}
+}
+
+func TestJitManyClosures(t *testing.T) {
+	skipIfNoJit(t)
+	var b strings.Builder
+	b.WriteString("let n=30; [")
+	for i := 0; i < 12; i++ {
+		if i > 0 {
+			b.WriteString(",\n")
+		}
+		fmt.Fprintf(&b, "list(n).map(x->x+%d).sum()", i)
+	}
+	b.WriteString("]")
+
+	exp, err := eval(New().FunctionGenerator, b.String())
+	assert.NoError(t, err)
+
+	jit := newJitFG()
+	defer jit.GetJit().Cancel()
+	res, err := eval(jit.FunctionGenerator, b.String())
+	assert.NoError(t, err)
+	assertSameResult(t, exp, res)
+
+	st := jit.GetJit().Stats()
+	assert.EqualValues(t, 12, st.Compiled)
+	assert.EqualValues(t, 0, st.Failed)
+	assert.Equal(t, "closure in line 12", st.Functions[11].Name)
+}
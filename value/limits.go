@@ -0,0 +1,145 @@
+package value
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hneemann/parser2/funcGen"
+)
+
+// Limits restricts the size of the values created by an evaluation.
+// A limit which is zero is not checked.
+type Limits struct {
+	// MaxStringLength is the maximum length of a string in bytes
+	MaxStringLength int
+	// MaxListSize is the maximum number of items of an evaluated list
+	MaxListSize int
+	// MaxMapSize is the maximum number of keys of a map. It also limits
+	// the number of keys collected by methods like groupByString.
+	MaxMapSize int
+	// MaxDepth is the maximum nesting depth of lists and maps
+	MaxDepth int
+	// MaxWorkers is the maximum number of workers used by the parallel
+	// list methods. It can only lower the maximum set by SetMaxWorkers.
+	MaxWorkers int
+}
+
+// LimitError is returned if a value exceeds one of the Limits
+type LimitError struct {
+	// Limit is the name of the exceeded limit
+	Limit string
+	// Max is the value of the limit
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeds the limit of %d", e.Limit, e.Max)
+}
+
+type limitsKey struct{}
+
+// WithLimits returns a context which limits the size of the values created
+// by an evaluation started by EvalContext.
+func WithLimits(ctx context.Context, limits Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, &limits)
+}
+
+// limitsOf returns the limits of the evaluation st belongs to, nil if
+// there are none
+func limitsOf(st funcGen.Stack[Value]) *Limits {
+	if !st.Limited() {
+		return nil
+	}
+	l, _ := st.Context().Value(limitsKey{}).(*Limits)
+	return l
+}
+
+func (l *Limits) checkString(length int) error {
+	if l.MaxStringLength > 0 && length > l.MaxStringLength {
+		return &LimitError{Limit: "string length", Max: l.MaxStringLength}
+	}
+	return nil
+}
+
+func (l *Limits) checkList(size int) error {
+	if l.MaxListSize > 0 && size > l.MaxListSize {
+		return &LimitError{Limit: "list size", Max: l.MaxListSize}
+	}
+	return nil
+}
+
+func (l *Limits) checkMap(size int) error {
+	if l.MaxMapSize > 0 && size > l.MaxMapSize {
+		return &LimitError{Limit: "map size", Max: l.MaxMapSize}
+	}
+	return nil
+}
+
+func (l *Limits) checkDepth(v Value) error {
+	if l.MaxDepth > 0 {
+		if d, _ := nestingDepth(v, l.MaxDepth); d > l.MaxDepth {
+			return &LimitError{Limit: "nesting depth", Max: l.MaxDepth}
+		}
+	}
+	return nil
+}
+
+// checkItemDepth checks the depth of a value which is to be stored in a list or map
+func (l *Limits) checkItemDepth(item Value) error {
+	if l.MaxDepth > 0 {
+		if d, _ := nestingDepth(item, l.MaxDepth-1); d+1 > l.MaxDepth {
+			return &LimitError{Limit: "nesting depth", Max: l.MaxDepth}
+		}
+	}
+	return nil
+}
+
+// nestingDepth returns the nesting depth of lists and maps in v. A list of
+// scalars has the depth one. Lists that are not evaluated yet count as
+// depth one, in this case exact is false. The search stops as soon as the
+// depth exceeds max. The depth of an evaluated list is cached if it is exact.
+func nestingDepth(v Value, max int) (depth int, exact bool) {
+	switch v := v.(type) {
+	case *List:
+		if d := int(v.depth.Load()); d > 0 {
+			return d, true
+		}
+		if !v.itemsPresent.Load() || max <= 0 {
+			return 1, false
+		}
+		depth, exact = itemsDepth(max, func(yield func(Value) bool) {
+			for _, item := range v.items {
+				if !yield(item) {
+					return
+				}
+			}
+		})
+		if exact {
+			v.depth.Store(int64(depth))
+		}
+		return depth, exact
+	case Map:
+		if max <= 0 {
+			return 1, false
+		}
+		return itemsDepth(max, func(yield func(Value) bool) {
+			v.Iter(func(_ string, item Value) bool {
+				return yield(item)
+			})
+		})
+	}
+	return 0, true
+}
+
+func itemsDepth(max int, items func(yield func(Value) bool)) (int, bool) {
+	depth, exact := 1, true
+	items(func(item Value) bool {
+		d, e := nestingDepth(item, max-1)
+		exact = exact && e
+		if d+1 > depth {
+			depth = d + 1
+		}
+		return depth <= max
+	})
+	return depth, exact && depth <= max
+}
@@ -0,0 +1,90 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		exp    string
+		limits Limits
+		limit  string
+	}{
+		{name: "string", exp: "list(n).map(i->\"ab\").reduce((a,b)->a+a)", limits: Limits{MaxStringLength: 1000}, limit: "string length"},
+		{name: "list", exp: "list(n).map(i->i*2).size()", limits: Limits{MaxListSize: 1000}, limit: "list size"},
+		{name: "listAdd", exp: "let l=list(n).top(800);\nl+l", limits: Limits{MaxListSize: 1000}, limit: "list size"},
+		{name: "cross", exp: "let l=list(n).top(200);\nl.cross(l,(a,b)->a*b)", limits: Limits{MaxListSize: 1000}, limit: "list size"},
+		{name: "append", exp: "[n].append(1).append(2)", limits: Limits{MaxListSize: 2}, limit: "list size"},
+		{name: "put", exp: "{a:n}.put(\"b\",1).put(\"c\",2)", limits: Limits{MaxMapSize: 2}, limit: "map size"},
+		{name: "mapAdd", exp: "{a:n}+{b:1,c:2}", limits: Limits{MaxMapSize: 2}, limit: "map size"},
+		{name: "listString", exp: "list(n).string()", limits: Limits{MaxStringLength: 1000}, limit: "string length"},
+		{name: "mapString", exp: "{a:list(n)}.string()", limits: Limits{MaxStringLength: 1000}, limit: "string length"},
+		{name: "replace", exp: "(\"b\"+n).replace(\"b\",\"bbbbbbbbbb\").replace(\"b\",\"bbbbbbbbbb\").replace(\"b\",\"bbbbbbbbbb\")", limits: Limits{MaxStringLength: 100}, limit: "string length"},
+		{name: "groupByString", exp: "list(n).groupByString(i->\"\"+i)", limits: Limits{MaxMapSize: 100}, limit: "map size"},
+		{name: "groupByInt", exp: "list(n).groupByInt(i->i)", limits: Limits{MaxMapSize: 100}, limit: "map size"},
+		{name: "groupByEqual", exp: "list(n).groupByEqual(i->i)", limits: Limits{MaxMapSize: 100}, limit: "map size"},
+		{name: "uniqueInt", exp: "list(n).uniqueInt(i->i)", limits: Limits{MaxMapSize: 100}, limit: "map size"},
+		{name: "depthEval", exp: "list(n).map(i->[[i]]).size()", limits: Limits{MaxDepth: 2}, limit: "nesting depth"},
+		{name: "depthAppend", exp: "[n].append([[1]])", limits: Limits{MaxDepth: 2}, limit: "nesting depth"},
+		{name: "depthAppendRepeated", exp: "list(n).reduce((a,b)->[].append(a))", limits: Limits{MaxDepth: 5}, limit: "nesting depth"},
+		{name: "depthPut", exp: "{a:n}.put(\"b\",[[1]])", limits: Limits{MaxDepth: 2}, limit: "nesting depth"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := New().Generate(test.exp, "n")
+			assert.NoError(t, err)
+			_, err = f.EvalContext(WithLimits(context.Background(), test.limits), Int(1000000))
+			var le *LimitError
+			if assert.True(t, errors.As(err, &le), err) {
+				assert.Equal(t, test.limit, le.Limit)
+			}
+		})
+	}
+
+	f, err := New().Generate("let l=list(n).map(i->[i]).append([1]);\n{a:l, b:\"x\"+l.size()}.put(\"c\",l.cross(list(3),(a,b)->b))", "n")
+	assert.NoError(t, err)
+	res, err := f.EvalContext(WithLimits(context.Background(), Limits{MaxStringLength: 10, MaxListSize: 100, MaxMapSize: 3, MaxDepth: 3}), Int(10))
+	assert.NoError(t, err)
+	m, ok := res.ToMap()
+	assert.True(t, ok)
+	b, _ := m.Get("b")
+	assert.Equal(t, String("x11"), b)
+
+	f, err = New().Generate("{a:n}+{b:1}", "n")
+	assert.NoError(t, err)
+	res, err = f.EvalContext(WithLimits(context.Background(), Limits{MaxMapSize: 2}), Int(10))
+	assert.NoError(t, err)
+	m, ok = res.ToMap()
+	assert.True(t, ok)
+	assert.Equal(t, 2, m.Size())
+}
+
+func TestNestingDepth(t *testing.T) {
+	lazy := NewListFromIterable(NewList(Int(1)).iterable)
+	tests := []struct {
+		name  string
+		value Value
+		depth int
+		exact bool
+	}{
+		{name: "scalar", value: Int(1), depth: 0, exact: true},
+		{name: "list", value: NewList(Int(1), Int(2)), depth: 1, exact: true},
+		{name: "empty", value: NewList(), depth: 1, exact: true},
+		{name: "nested", value: NewList(Int(1), NewList(NewList())), depth: 3, exact: true},
+		{name: "map", value: NewMap(RealMap{"a": NewList(Int(1))}), depth: 2, exact: true},
+		{name: "lazy", value: NewList(lazy), depth: 2, exact: false},
+		{name: "cutoff", value: NewList(NewList(NewList(NewList(NewList())))), depth: 4, exact: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			depth, exact := nestingDepth(test.value, 3)
+			assert.Equal(t, test.depth, depth)
+			assert.Equal(t, test.exact, exact)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hneemann/iterator"
 	"github.com/hneemann/parser2/funcGen"
@@ -44,11 +46,13 @@ func NewListOfMaps[I any](toMap ToMapInterface[I], items []I) *List {
 
 // NewList creates a new list containing the given elements
 func NewList(items ...Value) *List {
-	return &List{items: items, itemsPresent: true, iterable: createSliceIterable(items)}
+	l := &List{items: items, itemsIterable: createSliceIterable(items)}
+	l.itemsPresent.Store(true)
+	return l
 }
 
 func createSliceIterable(items []Value) iterator.Iterable[Value, funcGen.Stack[Value]] {
-	return func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+	return stepping(func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
 		return func(yield func(Value) bool) (bool, error) {
 			for _, item := range items {
 				if !yield(item) {
@@ -57,19 +61,62 @@ func createSliceIterable(items []Value) iterator.Iterable[Value, funcGen.Stack[V
 			}
 			return true, nil
 		}
-	}
+	})
 }
 
 // NewListFromIterable creates a list based on the given Iterable
 func NewListFromIterable(li iterator.Iterable[Value, funcGen.Stack[Value]]) *List {
-	return &List{iterable: li, itemsPresent: false}
+	return &List{source: stepping(li)}
 }
 
-// List represents a list of values
+// stepping counts each iteration step as an evaluation step, so that the
+// iteration of a list is stopped if the evaluation is limited and the limit
+// is exceeded.
+func stepping(li iterator.Iterable[Value, funcGen.Stack[Value]]) iterator.Iterable[Value, funcGen.Stack[Value]] {
+	return func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+		it := li(st)
+		if !st.Limited() {
+			return it
+		}
+		return func(yield func(Value) bool) (bool, error) {
+			var stepErr error
+			ok, err := it(func(v Value) bool {
+				if stepErr = st.Step(); stepErr != nil {
+					return false
+				}
+				return yield(v)
+			})
+			if stepErr != nil {
+				return false, stepErr
+			}
+			return ok, err
+		}
+	}
+}
+
+// List represents a list of values.
+// A list can be used concurrently. The items are evaluated only once.
 type List struct {
-	items        []Value
-	itemsPresent bool
-	iterable     iterator.Iterable[Value, funcGen.Stack[Value]]
+	// items and itemsIterable are set before itemsPresent is set
+	items         []Value
+	itemsIterable iterator.Iterable[Value, funcGen.Stack[Value]]
+	itemsPresent  atomic.Bool
+	// source creates the items of a list which is not evaluated yet
+	source iterator.Iterable[Value, funcGen.Stack[Value]]
+	// mutex guards the evaluation of the source
+	mutex sync.Mutex
+	// appended is set if the first append has used the spare capacity of items
+	appended atomic.Bool
+	// depth is the cached nesting depth of the items, zero if not known
+	depth atomic.Int64
+}
+
+// iterable returns the iterator of the list items
+func (l *List) iterable(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+	if l.itemsPresent.Load() {
+		return l.itemsIterable(st)
+	}
+	return l.source(st)
 }
 
 func (l *List) ToMap() (Map, bool) {
@@ -89,6 +136,7 @@ func (l *List) ToString(st funcGen.Stack[Value]) (string, error) {
 	b.WriteString("[")
 	first := true
 	var innerErr error
+	limits := limitsOf(st)
 	_, err := l.iterable(st)(func(v Value) bool {
 		if first {
 			first = false
@@ -101,6 +149,10 @@ func (l *List) ToString(st funcGen.Stack[Value]) (string, error) {
 			return false
 		}
 		b.WriteString(s)
+		if limits != nil {
+			innerErr = limits.checkString(b.Len() + 1)
+			return innerErr == nil
+		}
 		return true
 	})
 	if innerErr != nil {
@@ -113,6 +165,15 @@ func (l *List) ToString(st funcGen.Stack[Value]) (string, error) {
 	return b.String(), nil
 }
 
+// Summary implements funcGen.Summarizer. The list is not evaluated to
+// create the summary.
+func (l *List) Summary() string {
+	if l.itemsPresent.Load() {
+		return fmt.Sprintf("<list of %d items>", len(l.items))
+	}
+	return "<list>"
+}
+
 func (l *List) String() string {
 	var b bytes.Buffer
 	b.WriteString("[")
@@ -167,18 +228,35 @@ func (l *List) ToList() (*List, bool) {
 }
 
 func (l *List) Eval(st funcGen.Stack[Value]) error {
-	if !l.itemsPresent {
+	if l.itemsPresent.Load() {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.itemsPresent.Load() {
+		limits := limitsOf(st)
 		var it []Value
-		_, err := l.iterable(st)(func(value Value) bool {
+		var limitErr error
+		_, err := l.source(st)(func(value Value) bool {
 			it = append(it, value)
+			if limits != nil {
+				limitErr = limits.checkList(len(it))
+				if limitErr == nil {
+					limitErr = limits.checkItemDepth(value)
+				}
+				return limitErr == nil
+			}
 			return true
 		})
+		if limitErr != nil {
+			return limitErr
+		}
 		if err != nil {
 			return err
 		}
 		l.items = it
-		l.itemsPresent = true
-		l.iterable = createSliceIterable(it)
+		l.itemsIterable = createSliceIterable(it)
+		l.itemsPresent.Store(true)
 	}
 	return nil
 }
@@ -268,14 +346,40 @@ func (l *List) Append(st funcGen.Stack[Value]) (*List, error) {
 	if err != nil {
 		return nil, err
 	}
-	newList := append(l.items, st.Get(1))
-	// Guarantee a copy operation the next time append is called on this
-	// list, which is only a rare special case, as the new list is usually
-	// appended to.
-	if len(l.items) != cap(l.items) {
-		l.items = l.items[:len(l.items):len(l.items)]
+	item := st.Get(1)
+	depth := 0
+	if limits := limitsOf(st); limits != nil {
+		if err := limits.checkList(len(l.items) + 1); err != nil {
+			return nil, err
+		}
+		if limits.MaxDepth > 0 {
+			// the depth is calculated incrementally to keep appending efficient
+			ld, lExact := nestingDepth(l, limits.MaxDepth)
+			id, iExact := nestingDepth(item, limits.MaxDepth-1)
+			depth = ld
+			if id+1 > depth {
+				depth = id + 1
+			}
+			if depth > limits.MaxDepth {
+				return nil, &LimitError{Limit: "nesting depth", Max: limits.MaxDepth}
+			}
+			if !lExact || !iExact {
+				depth = 0
+			}
+		}
 	}
-	return NewList(newList...), nil
+	var newList []Value
+	if l.appended.CompareAndSwap(false, true) {
+		newList = append(l.items, item)
+	} else {
+		// Guarantee a copy operation if append is called a second time on
+		// this list, which is only a rare special case, as the new list is
+		// usually appended to.
+		newList = append(l.items[:len(l.items):len(l.items)], item)
+	}
+	nl := NewList(newList...)
+	nl.depth.Store(int64(depth))
+	return nl, nil
 }
 
 func (l *List) Size(st funcGen.Stack[Value]) (int, error) {
@@ -311,19 +415,21 @@ func (l *List) Accept(sta funcGen.Stack[Value]) (*List, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewListFromIterable(iterator.FilterAuto[Value](l.iterable, func() func(v Value) (bool, error) {
-		lst := funcGen.NewEmptyStack[Value]()
-		return func(v Value) (bool, error) {
-			eval, err := f.Eval(lst, v)
-			if err != nil {
-				return false, err
-			}
-			if accept, ok := eval.ToBool(); ok {
-				return accept, nil
+	return NewListFromIterable(func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+		return iterator.FilterAuto[Value](l.iterable, func() func(v Value) (bool, error) {
+			lst := st.New()
+			return func(v Value) (bool, error) {
+				eval, err := f.Eval(lst, v)
+				if err != nil {
+					return false, err
+				}
+				if accept, ok := eval.ToBool(); ok {
+					return accept, nil
+				}
+				return false, fmt.Errorf("function in accept does not return a bool")
 			}
-			return false, fmt.Errorf("function in accept does not return a bool")
-		}
-	})), nil
+		})(st)
+	}), nil
 }
 
 func (l *List) Map(sta funcGen.Stack[Value]) (*List, error) {
@@ -332,12 +438,14 @@ func (l *List) Map(sta funcGen.Stack[Value]) (*List, error) {
 		return nil, err
 	}
 
-	return NewListFromIterable(iterator.MapAuto[Value, Value](l.iterable, func() func(i int, v Value) (Value, error) {
-		lst := funcGen.NewEmptyStack[Value]()
-		return func(i int, v Value) (Value, error) {
-			return f.Eval(lst, v)
-		}
-	})), nil
+	return NewListFromIterable(func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+		return iterator.MapAuto[Value, Value](l.iterable, func() func(i int, v Value) (Value, error) {
+			lst := st.New()
+			return func(i int, v Value) (Value, error) {
+				return f.Eval(lst, v)
+			}
+		})(st)
+	}), nil
 
 }
 
@@ -393,6 +501,11 @@ func (l *List) Cross(sta funcGen.Stack[Value]) (*List, error) {
 		return nil, err
 	}
 	if otherList, ok := other.ToList(); ok {
+		if limits := limitsOf(sta); limits != nil && limits.MaxListSize > 0 {
+			if err := l.crossSize(sta, otherList, limits); err != nil {
+				return nil, err
+			}
+		}
 		return NewListFromIterable(iterator.Cross[Value, Value](l.iterable, otherList.iterable, func(st funcGen.Stack[Value], a, b Value) (Value, error) {
 			st.Push(a)
 			st.Push(b)
@@ -403,6 +516,22 @@ func (l *List) Cross(sta funcGen.Stack[Value]) (*List, error) {
 	}
 }
 
+// crossSize checks the size of the cross product of both lists in advance
+func (l *List) crossSize(st funcGen.Stack[Value], other *List, limits *Limits) error {
+	a, err := l.Size(st)
+	if err != nil {
+		return err
+	}
+	b, err := other.Size(st)
+	if err != nil {
+		return err
+	}
+	if b > 0 && a > limits.MaxListSize/b {
+		return &LimitError{Limit: "list size", Max: limits.MaxListSize}
+	}
+	return nil
+}
+
 func (l *List) Merge(sta funcGen.Stack[Value]) (*List, error) {
 	other := sta.Get(1)
 	f, err := ToFunc("merge", sta, 2, 2)
@@ -410,28 +539,30 @@ func (l *List) Merge(sta funcGen.Stack[Value]) (*List, error) {
 		return nil, err
 	}
 	if otherList, ok := other.ToList(); ok {
-		return NewListFromIterable(iterator.Merge[Value](l.iterable, otherList.iterable, func(st funcGen.Stack[Value], a, b Value) (bool, error) {
-			st.Push(a)
-			st.Push(b)
-			value, err2 := f.Func(st.CreateFrame(2), nil)
-			if err2 != nil {
-				return false, err2
-			}
-			if less, ok := value.ToBool(); ok {
-				return less, nil
-			} else {
-				return false, errors.New("function in merge needs to return a bool, (a<b)")
-			}
-		}, func() funcGen.Stack[Value] {
-			return funcGen.NewEmptyStack[Value]()
-		})), nil
+		return NewListFromIterable(func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+			return iterator.Merge[Value](l.iterable, otherList.iterable, func(st funcGen.Stack[Value], a, b Value) (bool, error) {
+				st.Push(a)
+				st.Push(b)
+				value, err2 := f.Func(st.CreateFrame(2), nil)
+				if err2 != nil {
+					return false, err2
+				}
+				if less, ok := value.ToBool(); ok {
+					return less, nil
+				} else {
+					return false, errors.New("function in merge needs to return a bool, (a<b)")
+				}
+			}, func() funcGen.Stack[Value] {
+				return st.New()
+			})(st)
+		}), nil
 	} else {
 		return nil, errors.New("first argument in merge needs to be a list")
 	}
 }
 
 func (l *List) First(st funcGen.Stack[Value]) (Value, error) {
-	if l.itemsPresent {
+	if l.itemsPresent.Load() {
 		if len(l.items) > 0 {
 			return l.items[0], nil
 		}
@@ -454,7 +585,7 @@ func (l *List) First(st funcGen.Stack[Value]) (Value, error) {
 }
 
 func (l *List) Last(st funcGen.Stack[Value]) (Value, error) {
-	if l.itemsPresent {
+	if l.itemsPresent.Load() {
 		if len(l.items) > 0 {
 			return l.items[len(l.items)-1], nil
 		}
@@ -1049,6 +1180,7 @@ func (l *List) GroupByEqual(st funcGen.Stack[Value], equal funcGen.BoolFunc[Valu
 
 	var items []item
 
+	limits := limitsOf(st)
 	var innerErr error
 	_, err = l.iterable(st)(func(value Value) bool {
 		key, err := keyFunc.Eval(st, value)
@@ -1069,6 +1201,10 @@ func (l *List) GroupByEqual(st funcGen.Stack[Value], equal funcGen.BoolFunc[Valu
 			}
 		}
 		items = append(items, item{key: key, values: []Value{value}})
+		if limits != nil {
+			innerErr = limits.checkMap(len(items))
+			return innerErr == nil
+		}
 		return true
 	})
 	if innerErr != nil {
@@ -1124,6 +1260,7 @@ func (l *List) GroupByInt(st funcGen.Stack[Value]) (*List, error) {
 
 func groupBy(st funcGen.Stack[Value], list *List, keyFunc func(Value) (Value, error)) (*List, error) {
 	m := make(map[Value]*[]Value)
+	limits := limitsOf(st)
 	var innerErr error
 	_, err := list.iterable(st)(func(value Value) bool {
 		key, err := keyFunc(value)
@@ -1136,6 +1273,10 @@ func groupBy(st funcGen.Stack[Value], list *List, keyFunc func(Value) (Value, er
 		} else {
 			ll := []Value{value}
 			m[key] = &ll
+			if limits != nil {
+				innerErr = limits.checkMap(len(m))
+				return innerErr == nil
+			}
 		}
 		return true
 	})
@@ -1191,6 +1332,7 @@ func (l *List) UniqueInt(st funcGen.Stack[Value]) (*List, error) {
 
 func unique(st funcGen.Stack[Value], list *List, keyFunc func(Value) (Value, error)) (*List, error) {
 	m := make(map[Value]struct{})
+	limits := limitsOf(st)
 	var innerErr error
 	_, err := list.iterable(st)(func(value Value) bool {
 		key, err := keyFunc(value)
@@ -1199,6 +1341,10 @@ func unique(st funcGen.Stack[Value], list *List, keyFunc func(Value) (Value, err
 			return false
 		}
 		m[key] = struct{}{}
+		if limits != nil {
+			innerErr = limits.checkMap(len(m))
+			return innerErr == nil
+		}
 		return true
 	})
 	if innerErr != nil {
@@ -1329,7 +1475,7 @@ func (l *List) containsAllItems(st funcGen.Stack[Value], lookForList *List, equa
 		return false, err
 	}
 
-	if l.itemsPresent && len(l.items) < len(lookFor) {
+	if l.itemsPresent.Load() && len(l.items) < len(lookFor) {
 		return false, nil
 	}
 
@@ -1,10 +1,15 @@
 package value
 
 import (
+	"context"
+	"errors"
 	"github.com/hneemann/parser2/funcGen"
 	"github.com/stretchr/testify/assert"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestList(t *testing.T) {
@@ -389,3 +394,158 @@ func TestParallelError2(t *testing.T) {
 	assert.Error(t, err)
 	assert.True(t, strings.Contains(err.Error(), "toLarge"))
 }
+
+func TestListEvalContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		exp      string
+		steps    int64
+		timeout  time.Duration
+		function string
+		line     int
+		cause    error
+	}{
+		{name: "size", exp: "list(n).size()", steps: 1000, cause: funcGen.ErrStepLimit},
+		{name: "map", exp: "list(n).map(i->i*2).sum()", steps: 1000, cause: funcGen.ErrStepLimit},
+		{name: "timeout", exp: "list(n).map(i->i*2).sum()", timeout: 50 * time.Millisecond, cause: context.DeadlineExceeded},
+		{name: "inner", exp: "func f(n)\n  list(n).sum();\nlist(10000).map(i->f(i)).sum()", steps: 10000, function: "f", line: 1, cause: funcGen.ErrStepLimit},
+		{name: "let", exp: "let l=list(n);\nl.reduce((a,b)->a+b)", steps: 5000, cause: funcGen.ErrStepLimit},
+		{name: "reduce", exp: "list(n)\n  .reduce((a,b)->a+list(b).size())", steps: 5001, line: 2, cause: funcGen.ErrStepLimit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := New().Generate(test.exp, "n")
+			assert.NoError(t, err)
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			if test.steps > 0 {
+				ctx = funcGen.WithStepLimit(ctx, test.steps)
+			}
+			start := time.Now()
+			_, err = f.EvalContext(ctx, Int(1000000000))
+			assert.Less(t, time.Since(start), 10*time.Second)
+			assert.ErrorIs(t, err, test.cause)
+			var se *funcGen.StoppedError
+			if assert.True(t, errors.As(err, &se)) {
+				assert.Equal(t, test.function, se.Function)
+				assert.EqualValues(t, test.line, se.Line)
+			}
+		})
+	}
+
+	f, err := New().Generate("list(100).map(i->i*2).sum()")
+	assert.NoError(t, err)
+	res, err := f.EvalContext(funcGen.WithStepLimit(context.Background(), 1000))
+	assert.NoError(t, err)
+	assert.Equal(t, Int(9900), res)
+}
+
+func TestListConcurrentEval(t *testing.T) {
+	// the constant lists are shared by all evaluations
+	f, err := New().Generate(`
+let c=list(100);
+let d=[1,2,3].append(4);
+let l=list(n).map(i->i*2);
+l.sum()+c.size()+c.map(i->i+n).sum()+d.append(n).size()+d.append(1).sum()`, "n")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				res, err := f.Eval(Int(n))
+				assert.NoError(t, err)
+				assert.Equal(t, Int(n*(n-1)+100+4950+100*n+5+11), res)
+			}
+		}(i + 10)
+	}
+	wg.Wait()
+}
+
+func TestParallelMap(t *testing.T) {
+	runTest(t, []testType{
+		{exp: "[1,2,3].parallelMap(e->e*2,2)", res: NewList(Int(2), Int(4), Int(6))},
+		{exp: "list(1000).parallelMap(e->e*2,4).sum()", res: Int(999000)},
+		{exp: "list(1000).parallelMap(e->e*2,0).sum()", res: Int(999000)},
+		{exp: "list(1000).parallelMap(e->e*2,4,false).sum()", res: Int(999000)},
+		{exp: "list(1000).parallelMap(e->e*2,4,false).order(e->e)=list(1000).map(e->e*2)", res: Bool(true)},
+		{exp: "list(1000).parallelMap(e->e*2,3)=list(1000).map(e->e*2)", res: Bool(true)},
+		{exp: "list(1000).parallelMap(e->e*2,3).first()", res: Int(0)},
+		{exp: "list(1000).parallelMap(e->e*2,3).top(5)", res: NewList(Int(0), Int(2), Int(4), Int(6), Int(8))},
+		{exp: "list(10).parallelAccept(e->e>5,2)", res: NewList(Int(6), Int(7), Int(8), Int(9))},
+		{exp: "list(1000).parallelAccept(e->e%3=0,4,false).size()", res: Int(334)},
+		{exp: "list(100).parallelAccept(e->e%3=0,4).parallelMap(e->e/3,4)=list(34)", res: Bool(true)},
+		{exp: "[].parallelMap(e->e*2,2).size()", res: Int(0)},
+	})
+}
+
+func TestParallelMapError(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  string
+		err  string
+	}{
+		{name: "map", exp: "list(1000).parallelMap(e->if e=500 then throw(\"fail\") else e,4).sum()", err: "fail"},
+		{name: "unordered", exp: "list(1000).parallelMap(e->if e=500 then throw(\"fail\") else e,4,false).sum()", err: "fail"},
+		{name: "accept", exp: "list(10).parallelAccept(e->\"a\",2).size()", err: "does not return a bool"},
+		{name: "source", exp: "list(10).map(e->if e=5 then throw(\"source\") else e).parallelMap(e->e,2).size()", err: "source"},
+		{name: "workers", exp: "list(10).parallelMap(e->e,-1)", err: "non-negative"},
+		{name: "args", exp: "list(10).parallelMap(e->e)", err: "number of workers"},
+		{name: "ordered", exp: "list(10).parallelMap(e->e,2,\"a\")", err: "ordered flag"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// constant expressions fail already at generation
+			f, err := New().Generate(test.exp)
+			if err == nil {
+				_, err = f.Eval()
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestParallelMapLimit(t *testing.T) {
+	f, err := New().Generate("list(n).parallelMap(e->e*2,4).sum()", "n")
+	assert.NoError(t, err)
+	_, err = f.EvalContext(funcGen.WithStepLimit(context.Background(), 1000), Int(1000000000))
+	var se *funcGen.StoppedError
+	assert.True(t, errors.As(err, &se))
+}
+
+func TestParallelMapMaxWorkers(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	fg := New().SetMaxWorkers(3).AddNativeFunction("track", func(i int) int {
+		r := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if r <= m || maxRunning.CompareAndSwap(m, r) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return i
+	})
+	f, err := fg.Generate("list(50).parallelMap(e->track(e),1000).sum()")
+	assert.NoError(t, err)
+
+	res, err := f.Eval()
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1225), res)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(3))
+
+	maxRunning.Store(0)
+	res, err = f.EvalContext(WithLimits(context.Background(), Limits{MaxWorkers: 1}))
+	assert.NoError(t, err)
+	assert.Equal(t, Int(1225), res)
+	assert.Equal(t, int32(1), maxRunning.Load())
+}
@@ -93,6 +93,7 @@ func (v Map) ToString(st funcGen.Stack[Value]) (string, error) {
 	b.WriteString("{")
 	first := true
 	var innerErr error
+	limits := limitsOf(st)
 	v.m.Iter(func(key string, v Value) bool {
 		if first {
 			first = false
@@ -107,6 +108,10 @@ func (v Map) ToString(st funcGen.Stack[Value]) (string, error) {
 			return false
 		}
 		b.WriteString(s)
+		if limits != nil {
+			innerErr = limits.checkString(b.Len() + 1)
+			return innerErr == nil
+		}
 		return true
 	})
 	if innerErr != nil {
@@ -135,6 +140,13 @@ func (v Map) ToClosure() (funcGen.Function[Value], bool) {
 func (v Map) ToMap() (Map, bool) {
 	return v, true
 }
+
+// Summary implements funcGen.Summarizer. The values of the map are not
+// converted to strings to create the summary.
+func (v Map) Summary() string {
+	return fmt.Sprintf("<map of %d entries>", v.Size())
+}
+
 func (v Map) Size() int {
 	return v.m.Size()
 }
@@ -312,6 +324,14 @@ func (v Map) PutM(stack funcGen.Stack[Value]) (Map, error) {
 			return EmptyMap, fmt.Errorf("key '%s' already present in map", k)
 		}
 		val := stack.Get(2)
+		if limits := limitsOf(stack); limits != nil {
+			if err := limits.checkMap(v.Size() + 1); err != nil {
+				return EmptyMap, err
+			}
+			if err := limits.checkItemDepth(val); err != nil {
+				return EmptyMap, err
+			}
+		}
 		return Map{AppendMap{key: k, value: val, parent: v.m}}, nil
 	}
 	return EmptyMap, errors.New("put requires a string as first argument")
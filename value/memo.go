@@ -0,0 +1,235 @@
+package value
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"sync"
+
+	"github.com/hneemann/parser2/funcGen"
+)
+
+// DefaultMemoSize is the default number of results stored by a memoized function
+const DefaultMemoSize = 1000
+
+type memoEntry struct {
+	hash   uint64
+	args   []Value
+	result Value
+}
+
+// memoCache is a LRU cache which stores the results of a function call.
+// The arguments are looked up by a structural hash, collisions are resolved
+// by comparing the arguments using the equal function.
+type memoCache struct {
+	mutex   sync.Mutex
+	seed    maphash.Seed
+	size    int
+	lru     *list.List
+	entries map[uint64][]*list.Element
+	equal   funcGen.BoolFunc[Value]
+}
+
+func newMemoCache(size int, equal funcGen.BoolFunc[Value]) *memoCache {
+	if size <= 0 {
+		size = DefaultMemoSize
+	}
+	return &memoCache{
+		seed:    maphash.MakeSeed(),
+		size:    size,
+		lru:     list.New(),
+		entries: map[uint64][]*list.Element{},
+		equal:   equal,
+	}
+}
+
+func (c *memoCache) get(st funcGen.Stack[Value], hash uint64, args []Value) (Value, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, e := range c.entries[hash] {
+		me := e.Value.(*memoEntry)
+		eq, err := c.argsEqual(st, me.args, args)
+		if err != nil {
+			return nil, false, err
+		}
+		if eq {
+			c.lru.MoveToFront(e)
+			return me.result, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (c *memoCache) argsEqual(st funcGen.Stack[Value], a, b []Value) (bool, error) {
+	for i, aa := range a {
+		eq, err := c.equal(st, aa, b[i])
+		if err != nil {
+			return false, err
+		}
+		if !eq {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (c *memoCache) put(hash uint64, args []Value, result Value) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[hash] = append(c.entries[hash], c.lru.PushFront(&memoEntry{hash: hash, args: args, result: result}))
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		c.removeFromBucket(oldest)
+	}
+}
+
+func (c *memoCache) removeFromBucket(e *list.Element) {
+	hash := e.Value.(*memoEntry).hash
+	bucket := c.entries[hash]
+	for i, be := range bucket {
+		if be == e {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(c.entries, hash)
+	} else {
+		c.entries[hash] = bucket
+	}
+}
+
+// hashArgs computes the structural hash of the given arguments.
+// If one of the arguments can not be hashed, false is returned.
+func (c *memoCache) hashArgs(st funcGen.Stack[Value], args []Value) (uint64, bool, error) {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	for _, a := range args {
+		ok, err := c.writeHash(st, &h, a)
+		if !ok || err != nil {
+			return 0, false, err
+		}
+	}
+	return h.Sum64(), true, nil
+}
+
+// writeHash writes the given value to the hash. The hash is
+// consistent with the equal function created by SetEqualLess:
+// Int and Float values are hashed by their float value and the
+// hash of a map does not depend on the order of its entries.
+func (c *memoCache) writeHash(st funcGen.Stack[Value], h *maphash.Hash, v Value) (bool, error) {
+	switch v := v.(type) {
+	case nilType:
+		h.WriteByte(0)
+	case Int, Float:
+		f, _ := v.ToFloat()
+		if f == 0 {
+			// -0 and 0 are equal
+			f = 0
+		}
+		h.WriteByte(1)
+		writeUint64(h, math.Float64bits(f))
+	case Bool:
+		h.WriteByte(2)
+		if v {
+			h.WriteByte(1)
+		} else {
+			h.WriteByte(0)
+		}
+	case String:
+		h.WriteByte(3)
+		h.WriteString(string(v))
+		h.WriteByte(0)
+	case *List:
+		items, err := v.ToSlice(st)
+		if err != nil {
+			return false, err
+		}
+		h.WriteByte(4)
+		writeUint64(h, uint64(len(items)))
+		for _, item := range items {
+			ok, err := c.writeHash(st, h, item)
+			if !ok || err != nil {
+				return false, err
+			}
+		}
+	case Map:
+		var sum uint64
+		ok := true
+		var innerErr error
+		v.Iter(func(key string, value Value) bool {
+			var eh maphash.Hash
+			eh.SetSeed(c.seed)
+			eh.WriteString(key)
+			eh.WriteByte(0)
+			ok, innerErr = c.writeHash(st, &eh, value)
+			if !ok || innerErr != nil {
+				return false
+			}
+			sum += eh.Sum64()
+			return true
+		})
+		if !ok || innerErr != nil {
+			return false, innerErr
+		}
+		h.WriteByte(5)
+		writeUint64(h, uint64(v.Size()))
+		writeUint64(h, sum)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func writeUint64(h *maphash.Hash, v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	h.Write(buf[:])
+}
+
+// Memoize returns a function which caches its results in a LRU cache of the
+// given size. It is the callers responsibility to only memoize pure functions.
+// Calls with arguments that can not be hashed, like closures, are not cached.
+func Memoize(f funcGen.Function[Value], size int, equal funcGen.BoolFunc[Value]) funcGen.Function[Value] {
+	cache := newMemoCache(size, equal)
+	inner := f.Func
+	f.Func = func(st funcGen.Stack[Value], cs []Value) (Value, error) {
+		args := st.ToSlice()
+		hash, ok, err := cache.hashArgs(st, args)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return inner(st, cs)
+		}
+		if v, found, err := cache.get(st, hash, args); err != nil || found {
+			return v, err
+		}
+		argsCopy := make([]Value, len(args))
+		copy(argsCopy, args)
+		v, err := inner(st, cs)
+		if err != nil {
+			return nil, err
+		}
+		cache.put(hash, argsCopy, v)
+		return v, nil
+	}
+	// the jit would bypass the cache
+	f.Ast = nil
+	f.JitCompiler = nil
+	return f
+}
+
+// Memoize implements the funcGen.Memoizer interface
+func (fg *FunctionGenerator) Memoize(f funcGen.Function[Value]) funcGen.Function[Value] {
+	return Memoize(f, fg.memoSize, fg.equal)
+}
+
+// SetMemoSize sets the number of results stored by memoized functions
+func (fg *FunctionGenerator) SetMemoSize(size int) *FunctionGenerator {
+	fg.CheckNotFrozen()
+	fg.memoSize = size
+	return fg
+}
@@ -0,0 +1,68 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemo(t *testing.T) {
+	runTest(t, []testType{
+		{exp: "memo func fib(n) if n<2 then n else fib(n-1)+fib(n-2); fib(80)", res: Int(23416728348467685)},
+		{exp: "memo func fib(n) if n<2 then n else fib(n-1)+fib(n-2); list(10).map(fib).string()", res: String("[0, 1, 1, 2, 3, 5, 8, 13, 21, 34]")},
+		{exp: "let f=(a,b)->a*b; let m=f.memo(); m(2,3)+m(2,3)", res: Int(12)},
+		{exp: "let m=(l->l.sum()).memo(); m([1,2,3])+m([1,2,3])", res: Int(12)},
+		{exp: "let m=(m->m.a+m.b).memo(); m({a:1,b:2})+m({b:2,a:1})", res: Int(6)},
+		{exp: "let m=(f->f(2)).memo(); m(x->x*2)+m(x->x*3)", res: Int(10)},
+		{exp: "memo func paths(x,y) if x=0 | y=0 then 1 else paths(x-1,y)+paths(x,y-1); paths(16,16)", res: Int(601080390)},
+		{exp: "func f(memo) memo+1; f(3)", res: Int(4)},
+		{exp: "let memo=3; memo*2", res: Int(6)},
+		{exp: "let f=memo->memo; f(5)", res: Int(5)},
+	})
+}
+
+func TestMemoCache(t *testing.T) {
+	calls := 0
+	f := funcGen.Function[Value]{
+		Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
+			calls++
+			return st.Get(0), nil
+		},
+		Args: 1,
+	}
+	m := Memoize(f, 2, New().equal)
+	st := funcGen.NewEmptyStack[Value]()
+	eval := func(v Value) Value {
+		r, err := m.Eval(st, v)
+		assert.NoError(t, err)
+		return r
+	}
+
+	assert.Equal(t, Int(1), eval(Int(1)))
+	assert.Equal(t, Int(1), eval(Int(1)))
+	assert.Equal(t, 1, calls)
+
+	// Int and Float are equal, so the cached value is returned
+	assert.Equal(t, Int(1), eval(Float(1)))
+	assert.Equal(t, 1, calls)
+
+	eval(Int(2))
+	eval(Int(3))
+	assert.Equal(t, 3, calls)
+
+	// 1 is evicted
+	eval(Int(1))
+	assert.Equal(t, 4, calls)
+	// 3 is still present
+	eval(Int(3))
+	assert.Equal(t, 4, calls)
+}
+
+func TestMemoSizeFrozen(t *testing.T) {
+	fg := New()
+	fg.Freeze()
+	assert.PanicsWithValue(t, "generator is frozen", func() {
+		fg.SetMemoSize(10)
+	})
+}
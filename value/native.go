@@ -0,0 +1,484 @@
+package value
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/hneemann/parser2/listMap"
+)
+
+var (
+	valueType = reflect.TypeOf((*Value)(nil)).Elem()
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// AddNativeFunction adds a go function as a static function. The signature
+// of the function is inspected by reflection, so that the arguments are
+// converted from and the results are converted to values. Supported are
+// the types int, float64, string, bool, Value, error and func as well as
+// slices, maps with string keys, structs and pointers of the supported
+// types. The function may return no result, a single value, an error or a
+// value followed by an error. Structs are converted to maps containing the
+// exported fields, the tags used by NewToMapReflection are respected. The function is not pure, so it is evaluated also if
+// all arguments are constant. If the signature is not supported, the
+// method panics.
+func (fg *FunctionGenerator) AddNativeFunction(name string, fn any) *FunctionGenerator {
+	f, err := nativeFunction(name, reflect.ValueOf(fn))
+	if err != nil {
+		panic(fmt.Errorf("native function %s: %w", name, err))
+	}
+	fg.AddStaticFunction(name, f)
+	return fg
+}
+
+// nativeFunction creates the function calling the given go function
+func nativeFunction(name string, fn reflect.Value) (funcGen.Function[Value], error) {
+	t := fn.Type()
+	if t.Kind() != reflect.Func {
+		return funcGen.Function[Value]{}, fmt.Errorf("%v is not a function", t)
+	}
+	if err := checkNativeFunc(t, map[reflect.Type]bool{}); err != nil {
+		return funcGen.Function[Value]{}, err
+	}
+	args := t.NumIn()
+	minArgs := args
+	if t.IsVariadic() {
+		args = -1
+		minArgs--
+	}
+	descrArgs := make([]string, t.NumIn())
+	for i := range descrArgs {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			descrArgs[i] = "[" + nativeTypeName(in.Elem()) + "...]"
+		} else {
+			descrArgs[i] = nativeTypeName(in)
+		}
+	}
+	return funcGen.Function[Value]{
+		Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
+			if st.Size() < minArgs {
+				return nil, fmt.Errorf("%s requires at least %d arguments, found %d", name, minArgs, st.Size())
+			}
+			in := make([]reflect.Value, st.Size())
+			for i := range in {
+				var err error
+				in[i], err = toGo(st, st.Get(i), argType(t, i))
+				if err != nil {
+					return nil, fmt.Errorf("argument %d of %s: %w", i+1, name, err)
+				}
+			}
+			return fromGoResults(st, fn.Call(in))
+		},
+		Args:   args,
+		IsPure: false,
+		Description: &funcGen.FunctionDescription{
+			Args:        descrArgs,
+			Description: fmt.Sprintf("Native function with the signature %v.", t),
+		},
+	}, nil
+}
+
+// argType returns the type of the i-th argument of the function
+func argType(t reflect.Type, i int) reflect.Type {
+	if t.IsVariadic() && i >= t.NumIn()-1 {
+		return t.In(t.NumIn() - 1).Elem()
+	}
+	return t.In(i)
+}
+
+// checkNativeFunc checks whether the signature of a function is supported.
+// The visited map contains the types already checked, so that recursive
+// types like linked lists are supported.
+func checkNativeFunc(t reflect.Type, visited map[reflect.Type]bool) error {
+	for i := 0; i < t.NumIn(); i++ {
+		if err := checkNativeType(argType(t, i), visited); err != nil {
+			return fmt.Errorf("argument %d: %w", i+1, err)
+		}
+	}
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		if err := checkNativeType(t.Out(0), visited); err != nil {
+			return fmt.Errorf("result: %w", err)
+		}
+	case 2:
+		if t.Out(1) != errorType {
+			return fmt.Errorf("the second result must be an error")
+		}
+		if err := checkNativeType(t.Out(0), visited); err != nil {
+			return fmt.Errorf("result: %w", err)
+		}
+	default:
+		return fmt.Errorf("at most two results are supported")
+	}
+	return nil
+}
+
+// checkNativeType checks whether a type can be converted
+func checkNativeType(t reflect.Type, visited map[reflect.Type]bool) error {
+	if t.Implements(valueType) || t == errorType || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		return nil
+	case reflect.Slice, reflect.Pointer:
+		return checkNativeType(t.Elem(), visited)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("map keys must be strings: %v", t)
+		}
+		return checkNativeType(t.Elem(), visited)
+	case reflect.Struct:
+		for _, f := range visibleFields(t) {
+			if err := checkNativeType(f.typ, visited); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+		}
+		return nil
+	case reflect.Func:
+		if err := checkNativeFunc(t, visited); err != nil {
+			return fmt.Errorf("%v: %w", t, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("type %v not supported", t)
+}
+
+// nativeTypeName returns the name of a type used in the function description
+func nativeTypeName(t reflect.Type) string {
+	if t.Implements(valueType) {
+		return "value"
+	}
+	if t == errorType {
+		return "error"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Slice:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "map"
+	case reflect.Pointer:
+		return nativeTypeName(t.Elem())
+	case reflect.Func:
+		return "func"
+	}
+	return t.String()
+}
+
+// toGo converts a value to a go value of the given type
+func toGo(st funcGen.Stack[Value], v Value, t reflect.Type) (reflect.Value, error) {
+	if t.Implements(valueType) {
+		r := reflect.New(t).Elem()
+		rv := reflect.ValueOf(v)
+		if !rv.Type().AssignableTo(t) {
+			return r, conversionError(v, nativeTypeName(t))
+		}
+		r.Set(rv)
+		return r, nil
+	}
+	if t == errorType {
+		r := reflect.New(t).Elem()
+		if v != NIL {
+			r.Set(reflect.ValueOf(newThrowError(st, v, 0)))
+		}
+		return r, nil
+	}
+	r := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt(v)
+		if !ok {
+			return r, conversionError(v, "int")
+		}
+		if r.OverflowInt(i) {
+			return r, fmt.Errorf("value %d overflows %v", i, t)
+		}
+		r.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := toInt(v)
+		if !ok {
+			return r, conversionError(v, "int")
+		}
+		if i < 0 || r.OverflowUint(uint64(i)) {
+			return r, fmt.Errorf("value %d overflows %v", i, t)
+		}
+		r.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := v.ToFloat()
+		if !ok {
+			return r, conversionError(v, "float")
+		}
+		r.SetFloat(f)
+	case reflect.String:
+		s, ok := v.(String)
+		if !ok {
+			return r, conversionError(v, "string")
+		}
+		r.SetString(string(s))
+	case reflect.Bool:
+		b, ok := v.ToBool()
+		if !ok {
+			return r, conversionError(v, "bool")
+		}
+		r.SetBool(b)
+	case reflect.Slice:
+		l, ok := v.ToList()
+		if !ok {
+			return r, conversionError(v, "list")
+		}
+		items, err := l.ToSlice(st)
+		if err != nil {
+			return r, err
+		}
+		r.Set(reflect.MakeSlice(t, len(items), len(items)))
+		for i, item := range items {
+			e, err := toGo(st, item, t.Elem())
+			if err != nil {
+				return r, fmt.Errorf("item %d: %w", i, err)
+			}
+			r.Index(i).Set(e)
+		}
+	case reflect.Map:
+		m, ok := v.ToMap()
+		if !ok {
+			return r, conversionError(v, "map")
+		}
+		r.Set(reflect.MakeMapWithSize(t, m.Size()))
+		var err error
+		m.Iter(func(key string, item Value) bool {
+			var e reflect.Value
+			e, err = toGo(st, item, t.Elem())
+			if err != nil {
+				err = fmt.Errorf("key %s: %w", key, err)
+				return false
+			}
+			r.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), e)
+			return true
+		})
+		if err != nil {
+			return r, err
+		}
+	case reflect.Struct:
+		m, ok := v.ToMap()
+		if !ok {
+			return r, conversionError(v, "map")
+		}
+		for _, f := range visibleFields(t) {
+			if item, ok := m.Get(f.name); ok {
+				e, err := toGo(st, item, f.typ)
+				if err != nil {
+					return r, fmt.Errorf("field %s: %w", f.name, err)
+				}
+				r.Field(f.index).Set(e)
+			}
+		}
+	case reflect.Pointer:
+		if v == NIL {
+			return r, nil
+		}
+		e, err := toGo(st, v, t.Elem())
+		if err != nil {
+			return r, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(e)
+		r.Set(p)
+	case reflect.Func:
+		c, ok := v.ToClosure()
+		if !ok {
+			return r, conversionError(v, "function")
+		}
+		if !t.IsVariadic() && c.Args >= 0 && c.Args != t.NumIn() {
+			return r, fmt.Errorf("function requires %d arguments, found %d", t.NumIn(), c.Args)
+		}
+		r.Set(reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+			return callClosure(st.New(), c, t, in)
+		}))
+	}
+	return r, nil
+}
+
+// toInt converts a value to an int. Floats are accepted if they are integers.
+func toInt(v Value) (int64, bool) {
+	switch n := v.(type) {
+	case Int:
+		return int64(n), true
+	case Float:
+		if n == Float(math.Trunc(float64(n))) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func conversionError(v Value, expected string) error {
+	return fmt.Errorf("expected %s, found %s", expected, TypeName(v))
+}
+
+// callClosure calls a closure by a go function of type t. If the closure
+// returns an error and t has no error result, the function panics.
+func callClosure(st funcGen.Stack[Value], c funcGen.Function[Value], t reflect.Type, in []reflect.Value) []reflect.Value {
+	if t.IsVariadic() {
+		last := in[len(in)-1]
+		in = in[:len(in)-1]
+		for i := 0; i < last.Len(); i++ {
+			in = append(in, last.Index(i))
+		}
+	}
+	args := make([]Value, len(in))
+	var err error
+	for i, a := range in {
+		args[i], err = fromGo(st, a)
+		if err != nil {
+			break
+		}
+	}
+	var res Value
+	if err == nil {
+		res, err = c.EvalSt(st, args...)
+	}
+
+	out := make([]reflect.Value, t.NumOut())
+	for i := range out {
+		out[i] = reflect.Zero(t.Out(i))
+	}
+	hasErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	if err == nil && t.NumOut() > 0 && !(hasErr && t.NumOut() == 1) {
+		out[0], err = toGo(st, res, t.Out(0))
+	}
+	if err != nil {
+		if !hasErr {
+			panic(err)
+		}
+		out[len(out)-1] = reflect.ValueOf(&err).Elem()
+	}
+	return out
+}
+
+// fromGoResults converts the results of a go function to a value
+func fromGoResults(st funcGen.Stack[Value], out []reflect.Value) (Value, error) {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err, ok := out[len(out)-1].Interface().(error); ok && err != nil {
+			return nil, err
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return NIL, nil
+	}
+	return fromGo(st, out[0])
+}
+
+// fromGo converts a go value to a value
+func fromGo(st funcGen.Stack[Value], r reflect.Value) (Value, error) {
+	return fromGoPath(st, r, map[uintptr]bool{})
+}
+
+// fromGoPath converts a go value to a value. The path contains the
+// pointers followed to reach the value, so that cyclic data structures
+// are detected.
+func fromGoPath(st funcGen.Stack[Value], r reflect.Value, path map[uintptr]bool) (Value, error) {
+	if r.Type().Implements(valueType) {
+		if r.Kind() == reflect.Interface && r.IsNil() {
+			return NIL, nil
+		}
+		return r.Interface().(Value), nil
+	}
+	if r.Type() == errorType {
+		if r.IsNil() {
+			return NIL, nil
+		}
+		return ToScriptError(r.Interface().(error)).ToMap(), nil
+	}
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(r.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fromUint(r.Uint())
+	case reflect.Float32, reflect.Float64:
+		return Float(r.Float()), nil
+	case reflect.String:
+		return String(r.String()), nil
+	case reflect.Bool:
+		return Bool(r.Bool()), nil
+	case reflect.Interface:
+		if r.IsNil() {
+			return NIL, nil
+		}
+		if v, ok := r.Interface().(Value); ok {
+			return v, nil
+		}
+		return fromGoPath(st, r.Elem(), path)
+	case reflect.Pointer:
+		if r.IsNil() {
+			return NIL, nil
+		}
+		p := r.Pointer()
+		if path[p] {
+			return nil, fmt.Errorf("cyclic reference of type %v", r.Type())
+		}
+		path[p] = true
+		defer delete(path, p)
+		return fromGoPath(st, r.Elem(), path)
+	case reflect.Slice:
+		items := make([]Value, r.Len())
+		for i := range items {
+			var err error
+			items[i], err = fromGoPath(st, r.Index(i), path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewList(items...), nil
+	case reflect.Map:
+		keys := r.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		m := listMap.New[Value](len(keys))
+		for _, k := range keys {
+			v, err := fromGoPath(st, r.MapIndex(k), path)
+			if err != nil {
+				return nil, err
+			}
+			m = m.Append(k.String(), v)
+		}
+		return NewMap(m), nil
+	case reflect.Struct:
+		fields := visibleFields(r.Type())
+		m := listMap.New[Value](len(fields))
+		for _, f := range fields {
+			v, err := fromGoPath(st, r.Field(f.index), path)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			m = m.Append(f.name, v)
+		}
+		return NewMap(m), nil
+	case reflect.Func:
+		if r.IsNil() {
+			return NIL, nil
+		}
+		f, err := nativeFunction("func", r)
+		if err != nil {
+			return nil, err
+		}
+		return Closure(f), nil
+	}
+	return nil, fmt.Errorf("type %v not supported", r.Type())
+}
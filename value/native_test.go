@@ -0,0 +1,169 @@
+package value
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/stretchr/testify/assert"
+)
+
+type nativePoint struct {
+	X, Y    float64
+	Name    string
+	private int
+}
+
+type nativeTagged struct {
+	Name   string   `parser2:"name"`
+	Secret string   `parser2:",omit"`
+	Skip   chan int `parser2:"-"`
+}
+
+type nativeNode struct {
+	Value int
+	Next  *nativeNode
+}
+
+func newNativeGen() *FunctionGenerator {
+	return New().
+		AddNativeFunction("pow", math.Pow).
+		AddNativeFunction("repeat", strings.Repeat).
+		AddNativeFunction("sum", func(n ...int) int {
+			s := 0
+			for _, i := range n {
+				s += i
+			}
+			return s
+		}).
+		AddNativeFunction("keys", func(m map[string]int) []string {
+			var k []string
+			for key := range m {
+				k = append(k, key)
+			}
+			return k
+		}).
+		AddNativeFunction("point", func(x, y float64) nativePoint { return nativePoint{X: x, Y: y, Name: "p"} }).
+		AddNativeFunction("length", func(p nativePoint) float64 { return math.Sqrt(p.X*p.X + p.Y*p.Y) }).
+		AddNativeFunction("div", func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		}).
+		AddNativeFunction("apply", func(f func(int) int, n []int) []int {
+			r := make([]int, len(n))
+			for i, v := range n {
+				r[i] = f(v)
+			}
+			return r
+		}).
+		AddNativeFunction("adder", func(a int) func(int) int { return func(b int) int { return a + b } }).
+		AddNativeFunction("opt", func(p *int) bool { return p == nil }).
+		AddNativeFunction("first", func(l *List) Value {
+			s, _ := l.ToSlice(funcGen.NewEmptyStack[Value]())
+			return s[0]
+		}).
+		AddNativeFunction("small", func(i int8) int8 { return i }).
+		AddNativeFunction("nothing", func() {}).
+		AddNativeFunction("sumv", func(a int, n ...int) int {
+			for _, i := range n {
+				a += i
+			}
+			return a
+		}).
+		AddNativeFunction("nodeSum", func(n *nativeNode) int {
+			s := 0
+			for ; n != nil; n = n.Next {
+				s += n.Value
+			}
+			return s
+		}).
+		AddNativeFunction("tagged", func(n string) nativeTagged { return nativeTagged{Name: n, Secret: "s"} }).
+		AddNativeFunction("taggedName", func(t nativeTagged) string { return t.Name + t.Secret }).
+		AddNativeFunction("maxUint", func() uint64 { return math.MaxUint64 }).
+		AddNativeFunction("cycle", func() *nativeNode {
+			n := &nativeNode{Value: 1}
+			n.Next = n
+			return n
+		})
+}
+
+func TestNativeFunction(t *testing.T) {
+	runTestWith(t, newNativeGen().FunctionGenerator, []testType{
+		{exp: "pow(2,10)", res: Float(1024)},
+		{exp: "repeat(\"ab\",3)", res: String("ababab")},
+		{exp: "sum()", res: Int(0)},
+		{exp: "sum(1,2,3)", res: Int(6)},
+		{exp: "keys({a:1})", res: NewList(String("a"))},
+		{exp: "point(3,4).X", res: Float(3)},
+		{exp: "point(3,4).Name", res: String("p")},
+		{exp: "length({X:3,Y:4})", res: Float(5)},
+		{exp: "length(point(3,4))", res: Float(5)},
+		{exp: "div(7,2)", res: Int(3)},
+		{exp: "try div(1,0) catch e->e.message", res: String("division by zero")},
+		{exp: "apply(x->x*x,[1,2,3])", res: NewList(Int(1), Int(4), Int(9))},
+		{exp: "adder(2)(3)", res: Int(5)},
+		{exp: "opt(nil)", res: Bool(true)},
+		{exp: "opt(1)", res: Bool(false)},
+		{exp: "first([7,8])", res: Int(7)},
+		{exp: "nothing()", res: NIL},
+		{exp: "sumv(1)", res: Int(1)},
+		{exp: "sumv(1,2,3)", res: Int(6)},
+		{exp: "nodeSum({Value:1,Next:{Value:2,Next:nil}})", res: Int(3)},
+		{exp: "tagged(\"a\").name", res: String("a")},
+		{exp: "tagged(\"a\").size()", res: Int(1)},
+		{exp: "taggedName({name:\"b\",Name:\"c\",Secret:\"d\"})", res: String("b")},
+	})
+}
+
+func TestNativeFunctionErrors(t *testing.T) {
+	tests := []struct {
+		exp string
+		err string
+	}{
+		{exp: "pow(\"a\",1)", err: "argument 1 of pow: expected float, found String"},
+		{exp: "repeat(\"a\",1.5)", err: "argument 2 of repeat: expected int, found Float"},
+		{exp: "sum(1,\"a\")", err: "argument 2 of sum: expected int, found String"},
+		{exp: "keys({a:\"b\"})", err: "argument 1 of keys: key a: expected int, found String"},
+		{exp: "length({X:\"a\"})", err: "argument 1 of length: field X: expected float, found String"},
+		{exp: "apply(x->x,[1,\"a\"])", err: "argument 2 of apply: item 1: expected int, found String"},
+		{exp: "apply((a,b)->a,[1])", err: "argument 1 of apply: function requires 1 arguments, found 2"},
+		{exp: "apply(x->\"a\",[1])", err: "expected int, found String"},
+		{exp: "first(1)", err: "argument 1 of first: expected value, found Int"},
+		{exp: "small(300)", err: "argument 1 of small: value 300 overflows int8"},
+		{exp: "pow(1)", err: "required 2, found 1"},
+		{exp: "sumv()", err: "sumv requires at least 1 arguments, found 0"},
+		{exp: "cycle()", err: "cyclic reference of type *value.nativeNode"},
+		{exp: "maxUint()", err: "value 18446744073709551615 overflows int"},
+	}
+	fg := newNativeGen()
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			assert.ErrorContains(t, evalError(fg.FunctionGenerator, test.exp), test.err)
+		})
+	}
+}
+
+func TestNativeFunctionUnsupported(t *testing.T) {
+	assert.PanicsWithError(t, "native function f: argument 1: map keys must be strings: map[int]int", func() {
+		New().AddNativeFunction("f", func(m map[int]int) {})
+	})
+	assert.PanicsWithError(t, "native function f: the second result must be an error", func() {
+		New().AddNativeFunction("f", func() (int, int) { return 0, 0 })
+	})
+	assert.PanicsWithError(t, "native function f: int is not a function", func() {
+		New().AddNativeFunction("f", 1)
+	})
+}
+
+func TestNativeFunctionDescription(t *testing.T) {
+	f, err := nativeFunction("f", reflect.ValueOf(func(a int, b []string, c ...float64) bool { return true }))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"int", "list", "[float...]"}, f.Description.Args)
+	assert.Equal(t, "Native function with the signature func(int, []string, ...float64) bool.", f.Description.Description)
+	assert.Equal(t, -1, f.Args)
+}
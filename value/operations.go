@@ -70,16 +70,38 @@ func Add(st funcGen.Stack[Value], a, b Value) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
+		if limits := limitsOf(st); limits != nil {
+			if err := limits.checkString(len(aa) + len(s)); err != nil {
+				return nil, err
+			}
+		}
 		return aa + String(s), nil
 	}
 	if aa, ok := a.(*List); ok {
 		if bb, ok := b.(*List); ok {
+			if limits := limitsOf(st); limits != nil && aa.itemsPresent.Load() && bb.itemsPresent.Load() {
+				if err := limits.checkList(len(aa.items) + len(bb.items)); err != nil {
+					return nil, err
+				}
+			}
 			return NewListFromIterable(iterator.Append(aa.iterable, bb.iterable)), nil
 		}
 	}
 	if aa, ok := a.(Map); ok {
 		if bb, ok := b.(Map); ok {
-			return aa.Merge(bb)
+			merged, err := aa.Merge(bb)
+			if err != nil {
+				return nil, err
+			}
+			if limits := limitsOf(st); limits != nil {
+				if err := limits.checkMap(merged.Size()); err != nil {
+					return nil, err
+				}
+				if err := limits.checkDepth(bb); err != nil {
+					return nil, err
+				}
+			}
+			return merged, nil
 		}
 	}
 	if aa, ok := a.ToFloat(); ok {
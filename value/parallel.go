@@ -0,0 +1,233 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/hneemann/iterator"
+	"github.com/hneemann/parser2"
+	"github.com/hneemann/parser2/funcGen"
+)
+
+// parallelJob is a single item processed by a worker
+type parallelJob struct {
+	item  Value
+	value Value
+	keep  bool
+	err   error
+	// ready is closed if the job is processed
+	ready chan struct{}
+}
+
+// parallelIterable applies the given function to the items of the iterable using
+// the given number of workers. If the function returns false, the item is
+// skipped. If ordered is true, the items are yielded in the order of the
+// source, otherwise in the order they are completed.
+// Each worker uses its own stack, so the function needs to be safe to
+// be called concurrently, which is the case for pure closures.
+func parallelIterable(in iterator.Iterable[Value, funcGen.Stack[Value]], workers int, ordered bool, f func(st funcGen.Stack[Value], item Value) (Value, bool, error)) iterator.Iterable[Value, funcGen.Stack[Value]] {
+	return func(st funcGen.Stack[Value]) iterator.Iterator[Value] {
+		return func(yield func(Value) bool) (bool, error) {
+			done := make(chan struct{})
+			jobs := make(chan *parallelJob, workers)
+			queue := make(chan *parallelJob, 2*workers)
+
+			// send passes a job to the given channel unless the iteration is stopped
+			send := func(c chan<- *parallelJob, j *parallelJob) bool {
+				select {
+				case c <- j:
+					return true
+				case <-done:
+					return false
+				}
+			}
+
+			var producer sync.WaitGroup
+			producer.Add(1)
+			go func() {
+				defer producer.Done()
+				defer close(jobs)
+				// the source is not iterated with the callers stack because
+				// the caller is using it concurrently
+				_, err := in(st.New())(func(item Value) bool {
+					j := &parallelJob{item: item, ready: make(chan struct{})}
+					if ordered && !send(queue, j) {
+						return false
+					}
+					return send(jobs, j)
+				})
+				if err != nil {
+					j := &parallelJob{err: err, ready: make(chan struct{})}
+					close(j.ready)
+					send(queue, j)
+				}
+			}()
+
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					wst := st.New()
+					for j := range jobs {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						j.value, j.keep, j.err = callParallel(f, wst, j.item)
+						close(j.ready)
+						if !ordered && !send(queue, j) {
+							return
+						}
+					}
+				}()
+			}
+
+			go func() {
+				producer.Wait()
+				wg.Wait()
+				close(queue)
+			}()
+
+			defer func() {
+				close(done)
+				producer.Wait()
+				wg.Wait()
+			}()
+
+			// the evaluation is stopped even if a worker is blocked
+			ctxDone := st.Context().Done()
+			for j := range queue {
+				select {
+				case <-j.ready:
+				case <-ctxDone:
+					return false, st.Step()
+				}
+				if j.err != nil {
+					return false, j.err
+				}
+				if j.keep && !yield(j.value) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	}
+}
+
+// callParallel calls the function and converts a panic to an error, because
+// a panic in a worker can not be recovered by the caller
+func callParallel(f func(st funcGen.Stack[Value], item Value) (Value, bool, error), st funcGen.Stack[Value], item Value) (v Value, keep bool, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic in parallel worker: %w", parser2.AnyToError(rec))
+		}
+	}()
+	return f(st, item)
+}
+
+// SetMaxWorkers sets the maximum number of workers used by the parallel
+// list methods. If a script requests more workers, the maximum is used.
+// By default, the number of cores is used.
+func (fg *FunctionGenerator) SetMaxWorkers(maxWorkers int) *FunctionGenerator {
+	fg.CheckNotFrozen()
+	fg.maxWorkers = maxWorkers
+	return fg
+}
+
+// workerLimit returns the maximum number of workers available to the
+// evaluation st belongs to
+func (fg *FunctionGenerator) workerLimit(st funcGen.Stack[Value]) int {
+	maxWorkers := fg.maxWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = runtime.NumCPU()
+	}
+	if limits := limitsOf(st); limits != nil && limits.MaxWorkers > 0 && limits.MaxWorkers < maxWorkers {
+		maxWorkers = limits.MaxWorkers
+	}
+	return maxWorkers
+}
+
+// parallelArgs reads the arguments of the parallel list methods. The number
+// of workers is limited to maxWorkers.
+func parallelArgs(name string, st funcGen.Stack[Value], maxWorkers int) (funcGen.Function[Value], int, bool, error) {
+	if st.Size() < 3 || st.Size() > 4 {
+		return funcGen.Function[Value]{}, 0, false, fmt.Errorf("%s requires a function, the number of workers and optionally the ordered flag", name)
+	}
+	f, err := ToFunc(name, st, 1, 1)
+	if err != nil {
+		return funcGen.Function[Value]{}, 0, false, err
+	}
+	workers, ok := st.Get(2).ToInt()
+	if !ok || workers < 0 {
+		return funcGen.Function[Value]{}, 0, false, fmt.Errorf("the number of workers in %s needs to be a non-negative int", name)
+	}
+	if workers == 0 || workers > maxWorkers {
+		workers = maxWorkers
+	}
+	ordered := true
+	if st.Size() == 4 {
+		if ordered, ok = st.Get(3).ToBool(); !ok {
+			return funcGen.Function[Value]{}, 0, false, fmt.Errorf("the ordered flag in %s needs to be a bool", name)
+		}
+	}
+	return f, workers, ordered, nil
+}
+
+// ParallelMap maps the list like Map, but the function is called by the
+// given number of workers concurrently. At most maxWorkers are used.
+func (l *List) ParallelMap(st funcGen.Stack[Value], maxWorkers int) (*List, error) {
+	f, workers, ordered, err := parallelArgs("parallelMap", st, maxWorkers)
+	if err != nil {
+		return nil, err
+	}
+	return NewListFromIterable(parallelIterable(l.iterable, workers, ordered, func(st funcGen.Stack[Value], item Value) (Value, bool, error) {
+		v, err := f.Eval(st, item)
+		return v, true, err
+	})), nil
+}
+
+// ParallelAccept filters the list like Accept, but the function is called
+// by the given number of workers concurrently. At most maxWorkers are used.
+func (l *List) ParallelAccept(st funcGen.Stack[Value], maxWorkers int) (*List, error) {
+	f, workers, ordered, err := parallelArgs("parallelAccept", st, maxWorkers)
+	if err != nil {
+		return nil, err
+	}
+	return NewListFromIterable(parallelIterable(l.iterable, workers, ordered, func(st funcGen.Stack[Value], item Value) (Value, bool, error) {
+		v, err := f.Eval(st, item)
+		if err != nil {
+			return nil, false, err
+		}
+		if accept, ok := v.ToBool(); ok {
+			return item, accept, nil
+		}
+		return nil, false, errors.New("function in parallelAccept does not return a bool")
+	})), nil
+}
+
+// createParallelListMethods creates the parallel list methods, which
+// use at most the number of workers set by SetMaxWorkers.
+func createParallelListMethods(fg *FunctionGenerator) MethodMap {
+	return MethodMap{
+		"parallelMap": MethodAtType(-1, func(list *List, stack funcGen.Stack[Value]) (Value, error) {
+			return list.ParallelMap(stack, fg.workerLimit(stack))
+		}).
+			SetMethodDescription("func(item) newItem", "workers", "ordered",
+				"Maps the list by the given function like map, but the function is called by the given number of workers "+
+					"concurrently. If workers is zero or exceeds the maximum number of workers, the maximum is used. "+
+					"If the optional ordered flag is false, the items are returned in the order they are completed. "+
+					"Otherwise the order is preserved."),
+		"parallelAccept": MethodAtType(-1, func(list *List, stack funcGen.Stack[Value]) (Value, error) {
+			return list.ParallelAccept(stack, fg.workerLimit(stack))
+		}).
+			SetMethodDescription("func(item) bool", "workers", "ordered",
+				"Filters the list by the given function like accept, but the function is called by the given number of "+
+					"workers concurrently. If workers is zero or exceeds the maximum number of workers, the maximum is used. "+
+					"If the optional ordered flag is false, the items are returned in the order they are completed. "+
+					"Otherwise the order is preserved."),
+	}
+}
@@ -0,0 +1,63 @@
+package value
+
+import (
+	"fmt"
+
+	"github.com/hneemann/parser2/funcGen"
+)
+
+// Policy restricts the capabilities available to the scripts. In addition
+// to the funcGen.Policy, the methods can be restricted per type.
+type Policy struct {
+	funcGen.Policy
+	// TypeMethods contains the methods which can be called on the values
+	// of a type. A type without a rule permits all of its methods.
+	// Since the type of the value a method is called on is usually not
+	// known before the evaluation, Generate only rejects methods which
+	// are not permitted by any type. The rule of the type is checked
+	// again if the method is called.
+	TypeMethods map[Type]funcGen.Rule
+}
+
+// SetPolicy sets the policy which restricts the capabilities available
+// to the scripts.
+func (fg *FunctionGenerator) SetPolicy(policy Policy) *FunctionGenerator {
+	fg.CheckNotFrozen()
+	fg.typeMethods = policy.TypeMethods
+	p := policy.Policy
+	if len(policy.TypeMethods) > 0 {
+		methods := p.Methods
+		p.Methods = func(name string) bool {
+			if methods != nil && !methods(name) {
+				return false
+			}
+			return fg.methodPermittedByAnyType(name)
+		}
+	}
+	fg.FunctionGenerator.SetPolicy(p)
+	return fg
+}
+
+// methodPermittedByAnyType returns true if there is a type which
+// permits the method
+func (fg *FunctionGenerator) methodPermittedByAnyType(name string) bool {
+	for t, mm := range fg.methods {
+		if mm == nil {
+			continue
+		}
+		rule, ok := fg.typeMethods[Type(t)]
+		if !ok || rule == nil || rule(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTypeMethod returns an error if the method is not permitted
+// for the type of the value
+func (fg *FunctionGenerator) checkTypeMethod(value Value, name string) error {
+	if rule, ok := fg.typeMethods[value.GetType()]; ok && rule != nil && !rule(name) {
+		return fmt.Errorf("method '%s' is not allowed for type %s", name, TypeName(value))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package value
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	listOnly := map[Type]funcGen.Rule{ListTypeId: funcGen.Allow("map", "size")}
+	allTypes := map[Type]funcGen.Rule{}
+	for t := Type(0); t < 20; t++ {
+		allTypes[t] = funcGen.Allow("size")
+	}
+	tests := []struct {
+		name     string
+		policy   Policy
+		exp      string
+		res      Value
+		genErr   string
+		evalErr  string
+		violated bool
+	}{
+		{name: "random", policy: Policy{Policy: funcGen.Policy{StaticFunctions: funcGen.Deny("random", "trace")}},
+			exp: "random()", genErr: "function 'random' is not allowed in line 1", violated: true},
+		{name: "trace", policy: Policy{Policy: funcGen.Policy{StaticFunctions: funcGen.Deny("random", "trace")}},
+			exp: "trace(l)", genErr: "function 'trace' is not allowed in line 1", violated: true},
+		{name: "list method", policy: Policy{TypeMethods: listOnly}, exp: "l.map(x->x*2).size()", res: Int(3)},
+		{name: "list method denied", policy: Policy{TypeMethods: listOnly}, exp: "l.sum()", evalErr: "method 'sum' is not allowed for type List"},
+		{name: "const list method denied", policy: Policy{TypeMethods: listOnly}, exp: "[1,2].sum()", genErr: "method 'sum' is not allowed for type List"},
+		{name: "string method", policy: Policy{TypeMethods: listOnly}, exp: "\"a\".toUpper()", res: String("A")},
+		{name: "no type", policy: Policy{TypeMethods: allTypes}, exp: "l.sum()", genErr: "method 'sum' is not allowed in line 1", violated: true},
+		{name: "methods", policy: Policy{Policy: funcGen.Policy{Methods: funcGen.Deny("sum")}, TypeMethods: listOnly}, exp: "l.sum()", genErr: "method 'sum' is not allowed in line 1", violated: true},
+		{name: "self application", policy: Policy{Policy: funcGen.Policy{Constructs: funcGen.Deny(funcGen.ConstructRecursion)}},
+			exp: "let f=(g,x)->if x=0 then 0 else g(g,x-1); f(f,100000)", genErr: "construct 'recursion' is not allowed in line 1", violated: true},
+		{name: "and", policy: Policy{Policy: funcGen.Policy{Operators: funcGen.Deny("&")}}, exp: "true & false", genErr: "operator '&' is not allowed in line 1", violated: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := New().SetPolicy(test.policy).Generate(test.exp, "l")
+			if test.genErr != "" {
+				assert.ErrorContains(t, err, test.genErr)
+				var pe *funcGen.PolicyError
+				assert.Equal(t, test.violated, errors.As(err, &pe))
+				return
+			}
+			assert.NoError(t, err)
+			res, err := f.Eval(NewList(Int(1), Int(2), Int(3)))
+			if test.evalErr != "" {
+				assert.ErrorContains(t, err, test.evalErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.res, res)
+		})
+	}
+}
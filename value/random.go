@@ -0,0 +1,172 @@
+package value
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/hneemann/parser2/funcGen"
+)
+
+type randomSeedKey struct{}
+
+// WithRandomSeed returns a context which makes the random numbers of an
+// evaluation started by EvalContext reproducible. The random source of the
+// evaluation is seeded with the given seed. The seed overrides the seed
+// set by SetRandomSeed. Like the limits, the seed does not apply to lazy
+// lists which are evaluated after the evaluation is finished.
+func WithRandomSeed(ctx context.Context, seed int64) context.Context {
+	return context.WithValue(ctx, randomSeedKey{}, seed)
+}
+
+// SetRandomSeed makes the random numbers reproducible. Each evaluation
+// gets its own random source which is seeded with the given seed. By
+// default, the global random source is used.
+func (fg *FunctionGenerator) SetRandomSeed(seed int64) *FunctionGenerator {
+	fg.CheckNotFrozen()
+	fg.randomSeed = &seed
+	return fg
+}
+
+// randomSource is the source of random numbers used by an evaluation.
+// If r is nil, the global source is used.
+type randomSource struct {
+	mutex sync.Mutex
+	r     *rand.Rand
+}
+
+var globalRandom = &randomSource{}
+
+func (rs *randomSource) intn(n int) int {
+	if rs.r == nil {
+		return rand.Intn(n)
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.r.Intn(n)
+}
+
+func (rs *randomSource) float64() float64 {
+	if rs.r == nil {
+		return rand.Float64()
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.r.Float64()
+}
+
+func (rs *randomSource) perm(n int) []int {
+	if rs.r == nil {
+		return rand.Perm(n)
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.r.Perm(n)
+}
+
+// random returns the random source of the evaluation st belongs to
+func (fg *FunctionGenerator) random(st funcGen.Stack[Value]) *randomSource {
+	seed := fg.randomSeed
+	if st.Limited() {
+		if s, ok := st.Context().Value(randomSeedKey{}).(int64); ok {
+			seed = &s
+		}
+	}
+	if seed == nil {
+		return globalRandom
+	}
+	return st.EvalState(randomSeedKey{}, func() any {
+		return &randomSource{r: rand.New(rand.NewSource(*seed))}
+	}).(*randomSource)
+}
+
+// randomFunc implements the random function. Without arguments, a float
+// in [0,1) is returned, with one argument n an int in [0,n) and with two
+// arguments a and b a value in [a,b), which is an int if a and b are ints.
+func (fg *FunctionGenerator) randomFunc(st funcGen.Stack[Value], cs []Value) (Value, error) {
+	rs := fg.random(st)
+	switch st.Size() {
+	case 0:
+		return Float(rs.float64()), nil
+	case 1:
+		if n, ok := st.Get(0).ToInt(); ok {
+			if n <= 0 {
+				return nil, fmt.Errorf("random needs a positive argument, found %d", n)
+			}
+			return Int(rs.intn(n)), nil
+		}
+		return nil, errors.New("random only allowed on int")
+	case 2:
+		a, b := st.Get(0), st.Get(1)
+		if ai, ok := a.(Int); ok {
+			if bi, ok := b.(Int); ok {
+				if bi <= ai {
+					return nil, fmt.Errorf("empty random range [%d,%d)", ai, bi)
+				}
+				return ai + Int(rs.intn(int(bi-ai))), nil
+			}
+		}
+		if af, ok := a.ToFloat(); ok {
+			if bf, ok := b.ToFloat(); ok {
+				if bf <= af {
+					return nil, fmt.Errorf("empty random range [%v,%v)", af, bf)
+				}
+				return Float(af + rs.float64()*(bf-af)), nil
+			}
+		}
+		return nil, errors.New("random range needs two numbers")
+	}
+	return nil, errors.New("random requires at most two arguments")
+}
+
+// shuffle returns a new list containing the items of the list in a random order
+func (l *List) shuffle(st funcGen.Stack[Value], rs *randomSource) (*List, error) {
+	items, err := l.ToSlice(st)
+	if err != nil {
+		return nil, err
+	}
+	shuffled := make([]Value, len(items))
+	for i, p := range rs.perm(len(items)) {
+		shuffled[i] = items[p]
+	}
+	return NewList(shuffled...), nil
+}
+
+// sample returns a new list containing n randomly chosen items of the list.
+// Each item is chosen at most once.
+func (l *List) sample(st funcGen.Stack[Value], rs *randomSource) (*List, error) {
+	n, ok := st.Get(1).(Int)
+	if !ok {
+		return nil, errors.New("sample requires an int")
+	}
+	items, err := l.ToSlice(st)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || int(n) > len(items) {
+		return nil, fmt.Errorf("sample size %d out of range, list contains %d items", n, len(items))
+	}
+	sample := make([]Value, n)
+	for i, p := range rs.perm(len(items))[:n] {
+		sample[i] = items[p]
+	}
+	return NewList(sample...), nil
+}
+
+// createRandomListMethods creates the list methods which use the random
+// source. The methods are not pure, so that they are not evaluated by the
+// optimizer.
+func createRandomListMethods(fg *FunctionGenerator) MethodMap {
+	shuffle := MethodAtType(0, func(list *List, st funcGen.Stack[Value]) (Value, error) { return list.shuffle(st, fg.random(st)) }).
+		SetMethodDescription("Returns a new list containing the items of the list in a random order.")
+	shuffle.IsPure = false
+	sample := MethodAtType(1, func(list *List, st funcGen.Stack[Value]) (Value, error) { return list.sample(st, fg.random(st)) }).
+		SetMethodDescription("n", "Returns a new list containing n randomly chosen items of the list. Each item is chosen at most once.")
+	sample.IsPure = false
+	return MethodMap{
+		"shuffle": shuffle,
+		"sample":  sample,
+	}
+}
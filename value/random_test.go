@@ -0,0 +1,99 @@
+package value
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hneemann/parser2/funcGen"
+	"github.com/stretchr/testify/assert"
+)
+
+func evalSlice(t *testing.T, f funcGen.Func[Value], ctx context.Context) []Value {
+	res, err := f.EvalContext(ctx)
+	assert.NoError(t, err)
+	l, ok := res.(*List)
+	assert.True(t, ok)
+	s, err := l.ToSlice(funcGen.NewEmptyStack[Value]())
+	assert.NoError(t, err)
+	return s
+}
+
+func evalString(t *testing.T, f funcGen.Func[Value], ctx context.Context) string {
+	res, err := f.EvalContext(ctx)
+	assert.NoError(t, err)
+	s, err := res.ToString(funcGen.NewEmptyStack[Value]())
+	assert.NoError(t, err)
+	return s
+}
+
+func TestRandomSeed(t *testing.T) {
+	exp := "[random(), random(10), random(5,8), random(1.5,2.5), list(10).shuffle(), list(10).sample(3), [1,2,3,4].shuffle()]"
+	f, err := New().SetRandomSeed(1).Generate(exp)
+	assert.NoError(t, err)
+
+	first := evalSlice(t, f, context.Background())
+	assert.Equal(t, evalString(t, f, context.Background()), evalString(t, f, context.Background()), "not reproducible")
+
+	r := first[0].(Float)
+	assert.True(t, r >= 0 && r < 1)
+	assert.True(t, first[1].(Int) >= 0 && first[1].(Int) < 10)
+	assert.True(t, first[2].(Int) >= 5 && first[2].(Int) < 8)
+	fl := first[3].(Float)
+	assert.True(t, fl >= 1.5 && fl < 2.5)
+
+	st := funcGen.NewEmptyStack[Value]()
+	shuffled, err := first[4].(*List).ToSlice(st)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Value{Int(0), Int(1), Int(2), Int(3), Int(4), Int(5), Int(6), Int(7), Int(8), Int(9)}, shuffled)
+	sample, err := first[5].(*List).ToSlice(st)
+	assert.NoError(t, err)
+	assert.Len(t, sample, 3)
+	assert.NotEqual(t, sample[0], sample[1])
+	assert.NotEqual(t, sample[1], sample[2])
+
+	seeded := evalString(t, f, WithRandomSeed(context.Background(), 2))
+	assert.Equal(t, seeded, evalString(t, f, WithRandomSeed(context.Background(), 2)), "not reproducible")
+	assert.NotEqual(t, evalString(t, f, context.Background()), seeded)
+}
+
+func TestRandomContextSeed(t *testing.T) {
+	f, err := New().Generate("list(100).map(i->random(1000)).eval()")
+	assert.NoError(t, err)
+	a := evalSlice(t, f, WithRandomSeed(context.Background(), 5))
+	assert.Equal(t, a, evalSlice(t, f, WithRandomSeed(context.Background(), 5)))
+}
+
+func TestRandomMerge(t *testing.T) {
+	merged, err := New().Generate("let l=[1000].merge(list(1).map(i->random(1000)),(a,b)->a<b).eval(); [l.first(), random(1000)]")
+	assert.NoError(t, err)
+	plain, err := New().Generate("[random(1000), random(1000)]")
+	assert.NoError(t, err)
+	ctx := WithRandomSeed(context.Background(), 5)
+	assert.Equal(t, evalSlice(t, plain, ctx), evalSlice(t, merged, ctx))
+}
+
+func TestRandomFloatArgument(t *testing.T) {
+	f, err := New().SetRandomSeed(1).Generate("[random(10.0), random(6/2)]")
+	assert.NoError(t, err)
+	r := evalSlice(t, f, context.Background())
+	assert.True(t, r[0].(Int) >= 0 && r[0].(Int) < 10)
+	assert.True(t, r[1].(Int) >= 0 && r[1].(Int) < 3)
+}
+
+func TestRandomErrors(t *testing.T) {
+	tests := []struct {
+		exp string
+		err string
+	}{
+		{exp: "random(0)", err: "random needs a positive argument"},
+		{exp: "random(\"a\")", err: "random only allowed on int"},
+		{exp: "random(3,3)", err: "empty random range"},
+		{exp: "random(1,2,3)", err: "at most two arguments"},
+		{exp: "list(3).sample(4)", err: "sample size 4 out of range"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			assert.ErrorContains(t, evalError(New().FunctionGenerator, test.exp), test.err)
+		})
+	}
+}
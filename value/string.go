@@ -116,6 +116,12 @@ func (s String) Behind(st funcGen.Stack[Value]) (Value, error) {
 func (s String) Replace(st funcGen.Stack[Value]) (Value, error) {
 	if oldStr, ok := st.Get(1).(String); ok {
 		if newStr, ok := st.Get(2).(String); ok {
+			if limits := limitsOf(st); limits != nil && len(newStr) > len(oldStr) {
+				n := strings.Count(string(s), string(oldStr))
+				if err := limits.checkString(len(s) + n*(len(newStr)-len(oldStr))); err != nil {
+					return nil, err
+				}
+			}
 			return String(strings.Replace(string(s), string(oldStr), string(newStr), -1)), nil
 		}
 	}
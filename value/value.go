@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"math/rand"
 	"reflect"
 	"sort"
 	"strconv"
@@ -147,11 +146,16 @@ func (c Closure) ToString(funcGen.Stack[Value]) (string, error) {
 	return "<function>", nil
 }
 
+// Summary implements funcGen.Summarizer
+func (c Closure) Summary() string {
+	return "<function>"
+}
+
 func (c Closure) ToBool() (bool, bool) {
 	return false, false
 }
 
-func createClosureMethods() MethodMap {
+func createClosureMethods(memoizer funcGen.Memoizer[Value]) MethodMap {
 	return MethodMap{
 		"args": MethodAtType(0, func(c Closure, stack funcGen.Stack[Value]) (Value, error) { return Int(c.Args), nil }).
 			SetMethodDescription("Returns the number of arguments the function takes."),
@@ -173,6 +177,12 @@ func createClosureMethods() MethodMap {
 			}
 		}).
 			SetMethodDescription("arg_list", "Invokes the function. The values of the given list are passed to the function as arguments."),
+		"memo": MethodAtType(0, func(c Closure, stack funcGen.Stack[Value]) (Value, error) {
+			return Closure(memoizer.Memoize(funcGen.Function[Value](c))), nil
+		}).
+			SetMethodDescription("Returns a memoized version of the function. The results are cached, keyed by the " +
+				"arguments of the call. Should only be used on pure functions. Recursive calls of the original function " +
+				"are not cached, use 'memo func' to declare a memoized recursive function."),
 	}
 }
 
@@ -433,11 +443,31 @@ func (fg *FunctionGenerator) GenerateCustom(ast parser2.AST, gc funcGen.Generato
 					// impossible because condition is checked above
 					return nil, l.Errorf("internal catch error")
 				}
-				st.Push(String(tryErr.Error()))
+				se := ToScriptError(tryErr)
+				if se.Line == 0 {
+					se.Line = l
+				}
+				st.Push(se.ToMap())
 				return theFunc.Func(st.CreateFrame(1), cs)
 			}, nil
 		}
 	}
+	if fc, ok := ast.(*parser2.FunctionCall); ok && len(fc.Args) == 1 {
+		// throw is generated here to record the line of the error
+		if id, ok := fc.Func.(*parser2.Ident); ok && id.Name == "throw" {
+			argFunc, err := g.GenerateFunc(fc.Args[0], gc)
+			if err != nil {
+				return nil, err
+			}
+			return func(st funcGen.Stack[Value], cs []Value) (Value, error) {
+				v, err := argFunc(st, cs)
+				if err != nil {
+					return nil, fc.EnhanceErrorf(err, "error in function call to throw")
+				}
+				return nil, newThrowError(st, v, fc.Line)
+			}, nil
+		}
+	}
 	if op, ok := ast.(*parser2.Operate); ok {
 		// AND and OR with short evaluation
 		switch op.Operator {
@@ -532,9 +562,18 @@ func notAvail(name string) func(st funcGen.Stack[Value], a Value, b Value) (Valu
 
 type FunctionGenerator struct {
 	*funcGen.FunctionGenerator[Value]
-	methods [20]MethodMap
-	equal   funcGen.BoolFunc[Value]
-	less    funcGen.BoolFunc[Value]
+	methods  [20]MethodMap
+	equal    funcGen.BoolFunc[Value]
+	less     funcGen.BoolFunc[Value]
+	memoSize int
+
+	// typeMethods holds the methods permitted per type, see Policy
+	typeMethods map[Type]funcGen.Rule
+	// randomSeed is the seed of the random source, nil if the global source is used
+	randomSeed *int64
+	// maxWorkers is the maximum number of workers of the parallel list
+	// methods, zero if the number of cores is used
+	maxWorkers int
 }
 
 func (fg *FunctionGenerator) GetMethod(value Value, methodName string) (funcGen.Function[Value], error) {
@@ -544,14 +583,16 @@ func (fg *FunctionGenerator) GetMethod(value Value, methodName string) (funcGen.
 		return funcGen.Function[Value]{}, fmt.Errorf("no methods for type %s", TypeName(value))
 	}
 	m, err := methodMap.Get(methodName)
+	if err == nil {
+		err = fg.checkTypeMethod(value, methodName)
+	}
 	if err != nil {
 		return funcGen.Function[Value]{}, err
-	} else {
-		return m, nil
 	}
+	return m, nil
 }
 
-func (fg *FunctionGenerator) OptimizePostLetEval(value Value) {
+func (fg *FunctionGenerator) OptimizePostLetEval(st funcGen.Stack[Value], value Value) {
 	// Here we check whether the result of the expresion that will be assigned
 	// to the variable is a list.
 	// If true we evaluated it and store the results in memory.
@@ -559,11 +600,12 @@ func (fg *FunctionGenerator) OptimizePostLetEval(value Value) {
 	// it is very likely to be used again
 	list, ok := value.ToList()
 	if ok {
-		list.Eval(funcGen.Stack[Value]{})
+		list.Eval(st)
 	}
 }
 
 func (fg *FunctionGenerator) RegisterMethods(id Type, methods MethodMap) *FunctionGenerator {
+	fg.CheckNotFrozen()
 	if fg.methods[id] == nil {
 		fg.methods[id] = methods
 	} else {
@@ -677,6 +719,7 @@ func New() *FunctionGenerator {
 		SetListHandler(f).
 		SetMapHandler(f).
 		SetClosureHandler(f).
+		SetMemoizer(f).
 		SetMethodHandler(f).
 		SetLetPostOptimizer(f).
 		SetCustomGenerator(f).
@@ -703,15 +746,13 @@ func New() *FunctionGenerator {
 		AddUnary("!", func(a Value) (Value, error) { return Not(a) }).
 		AddStaticFunction("throw", funcGen.Function[Value]{
 			Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
-				if s, ok := st.Get(0).(String); ok {
-					return nil, errors.New(string(s))
-				} else {
-					return nil, errors.New("fail needs a string as argument")
-				}
+				return nil, newThrowError(st, st.Get(0), 0)
 			},
 			Args:   1,
 			IsPure: false,
-		}.SetDescription("message", "Throws an error with the given message")).
+		}.SetDescription("value", "Throws an error. The value can be of any type, e.g. a map containing an error code. "+
+			"The catch closure receives a map containing the keys message, line, value and cause, "+
+			"where value is the thrown value.")).
 		AddStaticFunction("string", funcGen.Function[Value]{
 			Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
 				s, err := st.Get(0).ToString(st)
@@ -774,16 +815,13 @@ func New() *FunctionGenerator {
 			IsPure: true,
 		}.SetDescription("value", "Returns the square of the value.")).
 		AddStaticFunction("random", funcGen.Function[Value]{
-			Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
-				v := st.Get(0)
-				if n, ok := v.ToInt(); ok {
-					return Int(rand.Intn(n)), nil
-				}
-				return nil, errors.New("random only allowed on int")
-			},
-			Args:   1,
+			Func:   f.randomFunc,
+			Args:   -1,
 			IsPure: false,
-		}.SetDescription("n", "Returns a random integer between 0 and n-1.")).
+		}.SetDescription("[a]", "[b]", "Returns a random number. Without arguments, a float between 0 and 1 "+
+			"is returned, with an integer n as argument, an integer between 0 and n-1. With two arguments a and b, "+
+			"a number between a and b is returned, excluding b. The result is an integer if a and b are integers. "+
+			"The random numbers are reproducible if a seed is set.")).
 		AddStaticFunction("round", funcGen.Function[Value]{
 			Func: func(st funcGen.Stack[Value], cs []Value) (Value, error) {
 				v := st.Get(0)
@@ -850,12 +888,14 @@ func New() *FunctionGenerator {
 
 	return f.AddFinalizerValue(func(f *FunctionGenerator) {
 		f.RegisterMethods(ListTypeId, createListMethods(f.GetOpImpl("+"), f.GetOpImpl("/"), f.less, f.equal))
+		f.RegisterMethods(ListTypeId, createRandomListMethods(f))
+		f.RegisterMethods(ListTypeId, createParallelListMethods(f))
 		f.RegisterMethods(MapTypeId, createMapMethods())
 		f.RegisterMethods(StringTypeId, createStringMethods())
 		f.RegisterMethods(BoolTypeId, createBoolMethods())
 		f.RegisterMethods(IntTypeId, createIntMethods())
 		f.RegisterMethods(FloatTypeId, createFloatMethods())
-		f.RegisterMethods(closureTypeId, createClosureMethods())
+		f.RegisterMethods(closureTypeId, createClosureMethods(f))
 
 		less := f.less
 		f.AddStaticFunction("min", funcGen.Function[Value]{
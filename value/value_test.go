@@ -142,11 +142,17 @@ func TestBasic(t *testing.T) {
 		{exp: `func mySqrt(a)  
                  if a<0 then throw("sqrt of neg value") else sqrt(a);
 
-               try 2*mySqrt(-1)+1 catch e-> "sqrt of neg value" ~ e`, res: Bool(true)},
+               try 2*mySqrt(-1)+1 catch e-> "sqrt of neg value" ~ e.message`, res: Bool(true)},
 
 		{exp: "let p={a:1,b:2}; try p.a catch 5", res: Int(1)},
 		{exp: "let p={a:1,b:2}; try p.c catch 5", res: Int(5)},
-		{exp: "let p={a:1,b:2}; try p.c catch e->\"caught error: \"+e", res: String("caught error: key 'c' not found in map; available are: a, b")},
+		{exp: "let p={a:1,b:2}; try p.c catch e->\"caught error: \"+e.message", res: String("caught error: key 'c' not found in map; available are: a, b")},
+		{exp: "try throw({code:42,details:\"zzz\"}) catch e->e.value.code", res: Int(42)},
+		{exp: "try throw({code:42,message:\"zzz\"}) catch e->e.message", res: String("zzz")},
+		{exp: "try throw(42) catch e->[e.message,e.line,e.cause]", res: NewList(String("42"), Int(1), NIL)},
+		{exp: "let f=x->\n  throw(x);\ntry f(1) catch e->e.line", res: Int(2)},
+		{exp: "try try throw(\"inner\") catch e->throw(e) catch e->[e.message,e.value.message]", res: NewList(String("inner"), String("inner"))},
+		{exp: "try {a:1}.b catch e->[e.line,e.value,e.cause]", res: NewList(Int(1), NIL, NIL)},
 
 		{exp: "func sqr(a) a*a; sqr.args()", res: Int(1)},
 		{exp: "func sqr(a) a*a; sqr.invoke([2])", res: Int(4)},
@@ -159,7 +165,18 @@ func TestBasic(t *testing.T) {
 }
 
 func runTest(t *testing.T, tests []testType) {
-	valueParser := New()
+	runTestWith(t, New().FunctionGenerator, tests)
+}
+
+func eval(fg *funcGen.FunctionGenerator[Value], exp string) (Value, error) {
+	f, err := fg.Generate(exp)
+	if err != nil {
+		return nil, err
+	}
+	return f.Eval()
+}
+
+func runTestWith(t *testing.T, valueParser *funcGen.FunctionGenerator[Value], tests []testType) {
 	for _, test := range tests {
 		test := test
 		t.Run(shrinkSpace(test.exp), func(t *testing.T) {
@@ -222,6 +239,13 @@ func TestOptimizer(t *testing.T) {
 		{exp: "(1<2) & (2<3)", res: Bool(true)},
 		{exp: "-2/(-1)", res: Float(2)},
 		{exp: "const a=sqrt(2);const b=a*a; b", res: Float(2)},
+		{exp: "\"abc\".toUpper()", res: String("ABC")},
+		{exp: "\"a,b\".split(\",\")", res: NewList(String("a"), String("b"))},
+		{exp: "{a:1}.size()", res: Int(1)},
+		{exp: "[3,1,2].order(x->x)", res: NewList(Int(1), Int(2), Int(3))},
+		{exp: "[1,2,3].map(x->sqr(x)).sum()", res: Int(14)},
+		{exp: "\"a,b\".split(\",\").size()", res: Int(2)},
+		{exp: "list(10).map(x->x*2).sum()", res: Int(90)},
 	}
 
 	valueParser := New()
@@ -254,6 +278,25 @@ func TestOptimizer(t *testing.T) {
 	}
 }
 
+func TestOptimizerFoldingLimit(t *testing.T) {
+	tests := []string{
+		"list(100000).size()",
+		"list(100000).map(x->x*2).sum()",
+		"list(100000).order(x->-x).first()",
+	}
+
+	valueParser := New()
+	for _, test := range tests {
+		test := test
+		t.Run(test, func(t *testing.T) {
+			ast, err := valueParser.CreateAst(test)
+			assert.NoError(t, err, test)
+			_, ok := ast.(*parser2.MethodCall)
+			assert.True(t, ok, "method call expected: %v", ast)
+		})
+	}
+}
+
 // The power of closures and recursion.
 // Recursive implementation of the sqrt function using the Regula-Falsi algorithm.
 const regulaFalsi = `
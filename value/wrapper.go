@@ -1,7 +1,15 @@
 package value
 
 import (
+	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hneemann/parser2/listMap"
 )
 
 type ToMapInterface[S any] interface {
@@ -53,40 +61,263 @@ func (w toMapWrapper[S]) Size() int {
 	return len(w.attr)
 }
 
+// ToMapReflection creates maps from structs by reflection
 type ToMapReflection[S any] struct {
-	ToMap[reflect.Value]
+	*ToMap[reflect.Value]
+	ptr bool
 }
 
+// Create creates a map from the given struct. If S is a pointer and
+// the pointer is nil, an empty map is returned.
 func (wt *ToMapReflection[S]) Create(s S) Map {
-	return Map{toMapWrapper[reflect.Value]{container: reflect.ValueOf(s), attr: wt.attr}}
+	v := reflect.ValueOf(s)
+	if wt.ptr {
+		if v.IsNil() {
+			return EmptyMap
+		}
+		v = v.Elem()
+	}
+	return Map{toMapWrapper[reflect.Value]{container: v, attr: wt.attr}}
 }
 
+// NewToMapReflection creates a ToMapInterface for the struct S, or a
+// pointer to a struct, using reflection. All exported fields are accessible.
+// Numbers, strings and bools are converted to the corresponding values,
+// nested structs and maps to maps, slices and arrays to lists and a
+// time.Time to a string formatted according to RFC 3339. Nil pointers
+// and interfaces are converted to NIL. Fields of other types are skipped.
+// The key of a field can be changed by the tag `parser2:"name"`, the tag
+// `parser2:",omit"` or `parser2:"-"` hides the field. The field plan of a
+// type is created once and shared by all instances. Since a map can not
+// return an error, reading an unsigned field whose value exceeds the range
+// of an int panics.
 func NewToMapReflection[S any]() ToMapInterface[S] {
-	var zero S
-	t := reflect.TypeOf(zero)
-	tm := &ToMapReflection[S]{ToMap[reflect.Value]{attr: make(funcMap[reflect.Value])}}
+	t := reflect.TypeOf((*S)(nil)).Elem()
+	ptr := t.Kind() == reflect.Pointer
+	if ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("%v is not a struct", t))
+	}
+	reflectPlans.mutex.Lock()
+	defer reflectPlans.mutex.Unlock()
+	return &ToMapReflection[S]{ToMap: reflectPlans.structPlan(t), ptr: ptr}
+}
+
+// reflectConverter converts a go value to a value
+type reflectConverter func(reflect.Value) Value
+
+// reflectPlans caches the field plans of the struct types and the
+// converters of all other types
+var reflectPlans = &reflectCache{
+	structs:    map[reflect.Type]*ToMap[reflect.Value]{},
+	fields:     map[reflect.Type][]structField{},
+	converters: map[reflect.Type]reflectConverter{},
+}
+
+type reflectCache struct {
+	mutex      sync.Mutex
+	structs    map[reflect.Type]*ToMap[reflect.Value]
+	fields     map[reflect.Type][]structField
+	converters map[reflect.Type]reflectConverter
+}
+
+// structField is a field of a struct which is visible as a map entry
+type structField struct {
+	index int
+	name  string
+	typ   reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structPlan returns the field plan of a struct type. The plan is stored
+// before the fields are added, so that recursive types are supported.
+func (c *reflectCache) structPlan(t reflect.Type) *ToMap[reflect.Value] {
+	if tm, ok := c.structs[t]; ok {
+		return tm
+	}
+	tm := NewToMap[reflect.Value]()
+	c.structs[t] = tm
+	for _, field := range c.structFields(t) {
+		conv := c.converter(field.typ)
+		if conv == nil {
+			continue
+		}
+		index := field.index
+		tm.Attr(field.name, func(s reflect.Value) Value { return conv(s.Field(index)) })
+	}
+	return tm
+}
+
+// structFields returns the exported fields of a struct type which are not
+// hidden by a tag
+func (c *reflectCache) structFields(t reflect.Type) []structField {
+	if fs, ok := c.fields[t]; ok {
+		return fs
+	}
+	var fs []structField
 	for i := 0; i < t.NumField(); i++ {
-		i := i
 		field := t.Field(i)
-		name := field.Name
-		switch field.Type.Kind() {
-		case reflect.Int8:
-			fallthrough
-		case reflect.Int16:
-			fallthrough
-		case reflect.Int32:
-			fallthrough
-		case reflect.Int:
-			tm.Attr(name, func(s reflect.Value) Value { return Int(s.Field(i).Int()) })
-		case reflect.Bool:
-			tm.Attr(name, func(s reflect.Value) Value { return Bool(s.Field(i).Bool()) })
-		case reflect.Float32:
-			fallthrough
-		case reflect.Float64:
-			tm.Attr(name, func(s reflect.Value) Value { return Float(s.Field(i).Float()) })
-		case reflect.String:
-			tm.Attr(name, func(s reflect.Value) Value { return String(s.Field(i).String()) })
+		if !field.IsExported() {
+			continue
+		}
+		name, omit := fieldName(field)
+		if omit {
+			continue
 		}
+		fs = append(fs, structField{index: i, name: name, typ: field.Type})
 	}
-	return tm
+	c.fields[t] = fs
+	return fs
+}
+
+// visibleFields returns the fields of a struct type which are visible as
+// map entries
+func visibleFields(t reflect.Type) []structField {
+	reflectPlans.mutex.Lock()
+	defer reflectPlans.mutex.Unlock()
+	return reflectPlans.structFields(t)
+}
+
+// fromUint converts an unsigned int to a value. Values which exceed the
+// range of an int are rejected.
+func fromUint(u uint64) (Value, error) {
+	if u > math.MaxInt64 {
+		return nil, fmt.Errorf("value %d overflows int", u)
+	}
+	return Int(u), nil
+}
+
+// fieldName returns the key of a field and whether the field is hidden
+func fieldName(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("parser2")
+	if !ok {
+		return field.Name, false
+	}
+	if tag == "-" {
+		return "", true
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	for _, o := range strings.Split(options, ",") {
+		if o == "omit" {
+			return "", true
+		}
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+// converter returns the converter of the given type, nil if the type
+// is not supported
+func (c *reflectCache) converter(t reflect.Type) reflectConverter {
+	if conv, ok := c.converters[t]; ok {
+		return conv
+	}
+	conv := c.createConverter(t)
+	c.converters[t] = conv
+	return conv
+}
+
+func (c *reflectCache) createConverter(t reflect.Type) reflectConverter {
+	if t == timeType {
+		return func(v reflect.Value) Value {
+			return String(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		}
+	}
+	if t.Kind() != reflect.Interface && t.Implements(valueType) {
+		return func(v reflect.Value) Value { return v.Interface().(Value) }
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) Value { return Int(v.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value) Value {
+			i, err := fromUint(v.Uint())
+			if err != nil {
+				panic(err)
+			}
+			return i
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) Value { return Float(v.Float()) }
+	case reflect.String:
+		return func(v reflect.Value) Value { return String(v.String()) }
+	case reflect.Bool:
+		return func(v reflect.Value) Value { return Bool(v.Bool()) }
+	case reflect.Struct:
+		tm := c.structPlan(t)
+		return func(v reflect.Value) Value {
+			return Map{toMapWrapper[reflect.Value]{container: v, attr: tm.attr}}
+		}
+	case reflect.Pointer:
+		// the converter is stored first, because the element type may
+		// refer to the pointer type
+		var elem reflectConverter
+		c.converters[t] = func(v reflect.Value) Value {
+			if v.IsNil() {
+				return NIL
+			}
+			return elem(v.Elem())
+		}
+		elem = c.converter(t.Elem())
+		if elem == nil {
+			return nil
+		}
+		return c.converters[t]
+	case reflect.Slice, reflect.Array:
+		elem := c.converter(t.Elem())
+		if elem == nil {
+			return nil
+		}
+		return func(v reflect.Value) Value {
+			items := make([]Value, v.Len())
+			for i := range items {
+				items[i] = elem(v.Index(i))
+			}
+			return NewList(items...)
+		}
+	case reflect.Map:
+		elem := c.converter(t.Elem())
+		if elem == nil {
+			return nil
+		}
+		return func(v reflect.Value) Value {
+			keys := make([]string, 0, v.Len())
+			values := make(map[string]reflect.Value, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				k := fmt.Sprint(iter.Key().Interface())
+				keys = append(keys, k)
+				values[k] = iter.Value()
+			}
+			sort.Strings(keys)
+			m := listMap.New[Value](len(keys))
+			for _, k := range keys {
+				m = m.Append(k, elem(values[k]))
+			}
+			return NewMap(m)
+		}
+	case reflect.Interface:
+		return func(v reflect.Value) Value {
+			if v.IsNil() {
+				return NIL
+			}
+			if val, ok := v.Interface().(Value); ok {
+				return val
+			}
+			e := v.Elem()
+			reflectPlans.mutex.Lock()
+			conv := reflectPlans.converter(e.Type())
+			reflectPlans.mutex.Unlock()
+			if conv == nil {
+				return NIL
+			}
+			return conv(e)
+		}
+	}
+	return nil
 }
@@ -1,8 +1,11 @@
 package value
 
 import (
+	"github.com/hneemann/parser2/funcGen"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
+	"time"
 )
 
 // Example to show, how a simple struct can be converted
@@ -134,3 +137,81 @@ func Benchmark(b *testing.B) {
 		})
 	}
 }
+
+type nestedType struct {
+	Name     string `parser2:"name"`
+	Count    int64
+	Size     uint
+	Tags     []string
+	Scores   map[string]float32
+	Child    *nestedType
+	Inner    dataType
+	Created  time.Time
+	Any      any
+	Val      Value
+	Hidden   string `parser2:"hidden,omit"`
+	Ignored  string `parser2:"-"`
+	Channel  chan int
+	internal int
+}
+
+func TestNewReflectionNested(t *testing.T) {
+	tm := NewToMapReflection[nestedType]()
+	data := nestedType{
+		Name:    "root",
+		Count:   1 << 40,
+		Size:    3,
+		Tags:    []string{"a", "b"},
+		Scores:  map[string]float32{"y": 2, "x": 1},
+		Child:   &nestedType{Name: "child"},
+		Inner:   dataType{MyInt: 5},
+		Created: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Any:     12,
+		Val:     Bool(true),
+	}
+
+	fg := New()
+	tests := []struct {
+		exp string
+		res string
+	}{
+		{exp: "d.name", res: "root"},
+		{exp: "d.Count", res: "1099511627776"},
+		{exp: "d.Size", res: "3"},
+		{exp: "d.Tags", res: "[a, b]"},
+		{exp: "d.Tags.size()", res: "2"},
+		{exp: "d.Scores", res: "{x:1, y:2}"},
+		{exp: "d.Child.name", res: "child"},
+		{exp: "d.Child.Child", res: "nil"},
+		{exp: "d.Child.Any", res: "nil"},
+		{exp: "d.Inner.MyInt", res: "5"},
+		{exp: "d.Created", res: "2024-05-06T07:08:09Z"},
+		{exp: "d.Any", res: "12"},
+		{exp: "d.Val", res: "true"},
+		{exp: "d.size()", res: "10"},
+		{exp: "[\"Hidden\",\"hidden\",\"Ignored\",\"Channel\",\"internal\",\"Name\"].map(k->k~d)", res: "[false, false, false, false, false, false]"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			f, err := fg.Generate(test.exp, "d")
+			assert.NoError(t, err)
+			res, err := f.Eval(tm.Create(data))
+			assert.NoError(t, err)
+			s, err := res.ToString(funcGen.NewEmptyStack[Value]())
+			assert.NoError(t, err)
+			assert.Equal(t, test.res, s)
+		})
+	}
+
+	f, err := fg.Generate("d.Size", "d")
+	assert.NoError(t, err)
+	_, err = f.Eval(tm.Create(nestedType{Size: math.MaxUint}))
+	assert.ErrorContains(t, err, "value 18446744073709551615 overflows int")
+
+	ptm := NewToMapReflection[*nestedType]()
+	assert.Equal(t, 0, ptm.Create(nil).Size())
+	name, ok := ptm.Create(&data).Get("name")
+	assert.True(t, ok)
+	assert.Equal(t, String("root"), name)
+	assert.Same(t, tm.(*ToMapReflection[nestedType]).ToMap, ptm.(*ToMapReflection[*nestedType]).ToMap, "plan not cached")
+}